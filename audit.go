@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+
+	"secret-manager/multipart"
+)
+
+// callerIdentity returns the ARN of the AWS identity the credentials belong to
+// It is a variable so tests can substitute a fixed identity
+var callerIdentity = func(ctx context.Context, optFns ...func(*config.LoadOptions) error) (string, error) {
+	cfg, err := config.LoadDefaultConfig(ctx, optFns...)
+	if err != nil {
+		return "", err
+	}
+	resp, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(resp.Arn), nil
+}
+
+// localIdentity names the local user for audit entries of the file backend, which has no AWS identity
+func localIdentity() string {
+	u, err := user.Current()
+	if err != nil {
+		return "local"
+	}
+	return "local:" + u.Username
+}
+
+// auditEntry is one line of the --audit-log file
+type auditEntry struct {
+	Timestamp string `json:"timestamp"`
+	Base      string `json:"base"`
+	Part      string `json:"part"`
+	Operation string `json:"operation"`
+	Keys      int    `json:"keys"`
+	Bytes     int    `json:"bytes"`
+	Identity  string `json:"identity"`
+}
+
+// auditLog appends one JSON line per part written to a file
+// Parts are written concurrently, so each line is written and synced under a lock
+type auditLog struct {
+	mu       sync.Mutex
+	f        *os.File
+	identity string
+	// bases are the base names a part name is attributed to, e.g. the --copy-to target
+	bases  []string
+	naming multipart.Naming
+}
+
+// openAuditLog opens path for appending, creating it if needed
+func openAuditLog(path, identity string, naming multipart.Naming, bases ...string) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &auditLog{f: f, identity: identity, bases: bases, naming: naming}, nil
+}
+
+// record appends the entry for a write; the file is synced so the entry survives a crash right after
+func (l *auditLog) record(rec multipart.WriteRecord) error {
+	base := rec.Name
+	for _, b := range l.bases {
+		if _, ok := l.naming.ParsePartNumber(b, rec.Name); b != "" && ok {
+			base = b
+			break
+		}
+	}
+	js, err := json.Marshal(auditEntry{
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+		Base:      base,
+		Part:      rec.Name,
+		Operation: rec.Operation,
+		Keys:      rec.Keys,
+		Bytes:     rec.Bytes,
+		Identity:  l.identity,
+	})
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.f.Write(append(js, '\n')); err != nil {
+		return err
+	}
+	return l.f.Sync()
+}
+
+// Close closes the audit log file
+func (l *auditLog) Close() error {
+	return l.f.Close()
+}
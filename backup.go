@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"secret-manager/multipart"
+)
+
+// backupManifestFile is the name of the manifest written alongside the part files of a backup
+const backupManifestFile = "manifest.json"
+
+// backupManifest describes the part files contained in a backup directory
+type backupManifest struct {
+	BaseName  string               `json:"baseName"`
+	CreatedAt string               `json:"createdAt"`
+	Parts     []backupManifestPart `json:"parts"`
+}
+
+// backupManifestPart describes a single backed up part
+type backupManifestPart struct {
+	Name  string `json:"name"`
+	File  string `json:"file"`
+	Bytes int    `json:"bytes"`
+}
+
+// backupParts writes each part's raw SecretString into a new timestamped directory under root
+// together with a manifest, and returns the directory that was created
+func backupParts(root string, base string, parts []multipart.SecretPart, now time.Time) (string, error) {
+	stamp := now.UTC().Format("20060102T150405Z")
+	dir := filepath.Join(root, fmt.Sprintf("%s-%s", strings.ReplaceAll(base, "/", "_"), stamp))
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create backup directory '%s': %w", dir, err)
+	}
+
+	manifest := backupManifest{BaseName: base, CreatedAt: now.UTC().Format(time.RFC3339), Parts: []backupManifestPart{}}
+	for _, part := range parts {
+		file := strings.ReplaceAll(part.Name, "/", "_") + ".json"
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(part.Raw), 0o600); err != nil {
+			return "", fmt.Errorf("failed to write backup of '%s': %w", part.Name, err)
+		}
+		manifest.Parts = append(manifest.Parts, backupManifestPart{Name: part.Name, File: file, Bytes: multipart.SecretSize(part.Raw)})
+	}
+
+	js, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal backup manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, backupManifestFile), js, 0o600); err != nil {
+		return "", fmt.Errorf("failed to write backup manifest: %w", err)
+	}
+	return dir, nil
+}
+
+// loadBackup reads the manifest and part files of a backup directory
+// Every part file must be well-formed JSON and exactly as large as recorded in the manifest
+func loadBackup(dir string) (backupManifest, map[string]string, error) {
+	var manifest backupManifest
+	js, err := os.ReadFile(filepath.Join(dir, backupManifestFile))
+	if err != nil {
+		return manifest, nil, fmt.Errorf("failed to read backup manifest in '%s': %w", dir, err)
+	}
+	if err := json.Unmarshal(js, &manifest); err != nil {
+		return manifest, nil, fmt.Errorf("invalid backup manifest in '%s': %w", dir, err)
+	}
+	if manifest.BaseName == "" || len(manifest.Parts) == 0 {
+		return manifest, nil, fmt.Errorf("backup manifest in '%s' lists no base name or parts", dir)
+	}
+
+	contents := make(map[string]string, len(manifest.Parts))
+	for _, part := range manifest.Parts {
+		raw, err := os.ReadFile(filepath.Join(dir, part.File))
+		if err != nil {
+			return manifest, nil, fmt.Errorf("failed to read backup of '%s': %w", part.Name, err)
+		}
+		var data map[string]interface{}
+		if err := json.Unmarshal(raw, &data); err != nil || data == nil {
+			return manifest, nil, fmt.Errorf("backup of '%s' is not a valid JSON object", part.Name)
+		}
+		if multipart.SecretSize(string(raw)) != part.Bytes {
+			return manifest, nil, fmt.Errorf("backup of '%s' is %d bytes but the manifest records %d", part.Name, multipart.SecretSize(string(raw)), part.Bytes)
+		}
+		contents[part.Name] = string(raw)
+	}
+	return manifest, contents, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/smithy-go"
+	"secret-manager/multipart"
+)
+
+// Exit codes of run, so wrapping scripts can branch on the failure category
+const (
+	exitOK       = 0
+	exitFailure  = 1 // any other failure, e.g. invalid input or a failed --verify
+	exitUsage    = 2 // invalid flags or flag combinations
+	exitNotFound = 3 // the secret or key does not exist
+	exitConflict = 4 // the key or copy target already exists, a type or duplicate-key conflict, or a concurrent modification
+	exitAWS      = 5 // an AWS API error or a timed out call
+)
+
+// exitCodes documents the exit codes in the usage text
+const exitCodes = `Exit codes:
+  0  success
+  1  other failure (invalid input, failed --verify, ...)
+  2  usage error
+  3  secret or key not found
+  4  conflict (key or copy target exists, type or duplicate-key conflict, concurrent modification)
+  5  AWS error or timeout
+`
+
+// exitCode maps err to the exit code of its category
+func exitCode(err error) int {
+	switch classifyError(err).Code {
+	case multipart.CodeUsage:
+		return exitUsage
+	case multipart.CodeSecretNotFound, multipart.CodeKeyNotFound:
+		return exitNotFound
+	case multipart.CodeKeyExists, multipart.CodeTargetExists, multipart.CodeTypeConflict, multipart.CodeDuplicateKey, multipart.CodeConcurrentModification:
+		return exitConflict
+	case multipart.CodeAWS, multipart.CodeTimeout:
+		return exitAWS
+	}
+	return exitFailure
+}
+
+// errorReport is the --errors json representation of a failure
+type errorReport struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Key     string `json:"key,omitempty"`
+	Secret  string `json:"secret,omitempty"`
+	AWSCode string `json:"awsCode,omitempty"`
+}
+
+// classifyError builds the report for err: the innermost explicit code wins, otherwise the
+// code is derived from well-known causes such as timeouts or AWS API errors
+func classifyError(err error) errorReport {
+	report := errorReport{Code: multipart.CodeInternal, Message: err.Error()}
+	var coded *multipart.CodedError
+	var batchErr *multipart.BatchSecretError
+	var notFound *types.ResourceNotFoundException
+	var apiErr smithy.APIError
+	switch {
+	case errors.As(err, &coded):
+		// Use the innermost code so wrapping with context does not hide it
+		for {
+			report.Code, report.Key = coded.Code, coded.Key
+			if !errors.As(coded.Err, &coded) {
+				break
+			}
+		}
+	case errors.Is(err, context.DeadlineExceeded):
+		report.Code = multipart.CodeTimeout
+	case errors.Is(err, context.Canceled):
+		report.Code = multipart.CodeInterrupted
+	case errors.Is(err, multipart.ErrConcurrentModification):
+		report.Code = multipart.CodeConcurrentModification
+	case errors.As(err, &batchErr):
+		report.Code, report.Secret, report.AWSCode = multipart.CodeAWS, batchErr.SecretID, batchErr.Code
+		if batchErr.Code == "ResourceNotFoundException" {
+			report.Code = multipart.CodeSecretNotFound
+		}
+	case errors.As(err, &notFound):
+		report.Code, report.AWSCode = multipart.CodeSecretNotFound, notFound.ErrorCode()
+	case errors.As(err, &apiErr):
+		report.Code, report.AWSCode = multipart.CodeAWS, apiErr.ErrorCode()
+	}
+	return report
+}
+
+// reportError writes err to w, as "ERROR: message" or, when asJSON is set by --errors json, as a single JSON object
+func reportError(w io.Writer, err error, asJSON bool) {
+	if !asJSON {
+		fmt.Fprintf(w, "ERROR: %v\n", err)
+		return
+	}
+	js, merr := json.Marshal(classifyError(err))
+	if merr != nil {
+		fmt.Fprintf(w, "ERROR: %v\n", err)
+		return
+	}
+	fmt.Fprintln(w, string(js))
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/theory/jsonpath"
+	"github.com/theory/jsonpath/spec"
+
+	"secret-manager/multipart"
+)
+
+// jsonPathMatch is a value selected by a --jsonpath expression
+type jsonPathMatch struct {
+	// Path is the dot-notation path of the value, usable as --json_path
+	Path  string
+	Part  string
+	Value interface{}
+}
+
+// parseJSONPath parses an RFC 9535 JSONPath expression given with --jsonpath
+func parseJSONPath(expr string) (*jsonpath.Path, error) {
+	p, err := jsonpath.Parse(expr)
+	if err != nil {
+		return nil, multipart.WithCode(multipart.CodeUsage, "", fmt.Errorf("invalid --jsonpath '%s': %w", expr, err))
+	}
+	return p, nil
+}
+
+// selectJSONPath evaluates expr against the merged data of parts, so filters and descendant
+// segments see every key; each match is attributed to the part holding its top-level key
+func selectJSONPath(parts []multipart.SecretPart, expr *jsonpath.Path) ([]jsonPathMatch, error) {
+	merged, err := multipart.MergeSecretParts(parts)
+	if err != nil {
+		return nil, err
+	}
+	owner := make(map[string]string, len(merged))
+	for _, part := range parts {
+		for k := range part.Data {
+			owner[k] = part.Name
+		}
+	}
+	// Object members have no order in Go maps, so matches are sorted by location for stable output
+	nodes := expr.SelectLocated(merged)
+	sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].Path.Compare(nodes[j].Path) < 0 })
+	matches := make([]jsonPathMatch, 0, len(nodes))
+	for _, node := range nodes {
+		path, top := normalizedToDotPath(node.Path)
+		matches = append(matches, jsonPathMatch{Path: path, Part: owner[top], Value: node.Node})
+	}
+	return matches, nil
+}
+
+// normalizedToDotPath converts a normalized path to dot notation and also returns its top-level key
+// The root itself (a query of just '$') has the empty path
+func normalizedToDotPath(np spec.NormalizedPath) (string, string) {
+	segments := make([]string, 0, len(np))
+	top := ""
+	for i, sel := range np {
+		switch s := sel.(type) {
+		case spec.Name:
+			if i == 0 {
+				top = string(s)
+			}
+			segments = append(segments, multipart.EscapePathSegment(string(s)))
+		case spec.Index:
+			segments = append(segments, strconv.Itoa(int(s)))
+		}
+	}
+	return strings.Join(segments, "."), top
+}
+
+// formatJSONPathValue formats a selected value like --get-value: strings raw, anything else as JSON
+// Binary values keep their encoded form here; printValue decodes them
+// Encrypted strings are decrypted when key is set
+func formatJSONPathValue(m jsonPathMatch, key []byte) (string, error) {
+	if s, ok := m.Value.(string); ok {
+		if key != nil && multipart.IsEncrypted(s) {
+			return decryptedValue(s, m.Path, key)
+		}
+		return s, nil
+	}
+	js, err := json.Marshal(m.Value)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal value at '%s': %w", m.Path, err)
+	}
+	return string(js), nil
+}
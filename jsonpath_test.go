@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"secret-manager/multipart"
+)
+
+func TestSelectJSONPathOrder(t *testing.T) {
+	parts := []multipart.SecretPart{
+		{Name: "app", Data: map[string]interface{}{"b": map[string]interface{}{"user": "u2"}, "a": map[string]interface{}{"user": "u1"}}},
+		{Name: "app-1", Data: map[string]interface{}{"l": []interface{}{"3", "1", "2"}, "c": map[string]interface{}{"user": "u3"}}},
+	}
+	tests := []struct {
+		expr string
+		// want holds path@part=value for each match, in output order
+		want []string
+	}{
+		{expr: "$..user", want: []string{"a.user@app=u1", "b.user@app=u2", "c.user@app-1=u3"}},
+		{expr: "$.l[*]", want: []string{"l.0@app-1=3", "l.1@app-1=1", "l.2@app-1=2"}},
+		{expr: "$.*.user", want: []string{"a.user@app=u1", "b.user@app=u2", "c.user@app-1=u3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			expr, err := parseJSONPath(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Map iteration order changes between runs, so one lucky order must not pass the test
+			for range 20 {
+				matches, err := selectJSONPath(parts, expr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := make([]string, 0, len(matches))
+				for _, m := range matches {
+					got = append(got, fmt.Sprintf("%s@%s=%v", m.Path, m.Part, m.Value))
+				}
+				if !slices.Equal(got, tt.want) {
+					t.Fatalf("matches = %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
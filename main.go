@@ -2,306 +2,2968 @@
 // This script implements a comprehensive approach for managing AWS Secrets Manager secrets.
 // Features:
 // - Add key-value pairs (bulk addition with full redistribution)
+// - Delete keys (top-level or nested via dot notation) with full redistribution
 // - Values can be simple strings or nested escaped JSON
-// - Automatic sorting of all keys alphabetically
+// - Automatic sorting of all keys alphabetically (or compact size-based packing)
 // - Automatic redistribution across multipart secrets
-// - 50KB limit per secret
+// - 50KB limit per secret (configurable up to the 64KB AWS limit)
 
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
 	"os"
+	"os/signal"
 	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"github.com/theory/jsonpath"
 	"github.com/tidwall/gjson"
+	"secret-manager/multipart"
 )
 
-const MaxSecretSizeBytes = 50 * 1024
+// AWSMaxSecretNameLength is the longest secret name AWS Secrets Manager accepts
+const AWSMaxSecretNameLength = 512
+
+// AWSMaxDescriptionLength is the longest secret description AWS Secrets Manager accepts
+const AWSMaxDescriptionLength = 2048
 
 var (
-	multipartSuffix = regexp.MustCompile("[1-5]$")
+	regionPattern    = regexp.MustCompile(`^[a-z]{2,}(-[a-z]+)+-[0-9]+$`)
+	invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9/_+=.@-]`)
 )
 
-func verifySecretName(secretName string) (string, error) {
+// verifySecretName trims the name and rejects names that look like a multipart part (base-1 .. base-maxParts
+// under the configured naming scheme)
+// or that AWS would reject: invalid characters, a leading/trailing '/', or a part name longer than 512 characters
+func verifySecretName(naming multipart.Naming, secretName string, maxParts int) (string, error) {
 	clean := strings.TrimSpace(secretName)
-	if multipartSuffix.MatchString(clean) {
-		return "", fmt.Errorf("multipart secret name provided: %s. Please provide the base secret name instead", clean)
+	if clean == "" {
+		return "", fmt.Errorf("secret name is empty")
+	}
+	if bad := invalidNameChars.FindString(clean); bad != "" {
+		return "", fmt.Errorf("secret name '%s' contains invalid character %q (allowed: letters, digits and /_+=.@-)", clean, bad)
+	}
+	if strings.HasPrefix(clean, "/") || strings.HasSuffix(clean, "/") {
+		return "", fmt.Errorf("secret name '%s' must not start or end with '/'", clean)
+	}
+	if longest := naming.PartName(clean, maxParts); len(longest) > AWSMaxSecretNameLength {
+		return "", fmt.Errorf("secret name '%s' is too long: part name '%s' would be %d characters (AWS limit %d)", clean, longest, len(longest), AWSMaxSecretNameLength)
+	}
+	if i := strings.LastIndex(clean, naming.Separator); i > 0 {
+		if num, ok := naming.ParsePartNumber(clean[:i], clean); ok && num <= maxParts {
+			return "", fmt.Errorf("multipart secret name provided: %s. Please provide the base secret name instead", clean)
+		}
 	}
 	return clean, nil
 }
 
-func getSecretSize(data string) int {
-	return len([]byte(data))
+// partDescription expands the --description template for the part named name of one of bases
+func partDescription(naming multipart.Naming, template, name string, bases ...string) string {
+	base, number := name, 0
+	for _, b := range bases {
+		if n, ok := naming.ParsePartNumber(b, name); b != "" && ok {
+			base, number = b, n
+			break
+		}
+	}
+	return strings.NewReplacer("{base}", base, "{part}", strconv.Itoa(number)).Replace(template)
+}
+
+// tagFlags collects repeatable --tag key=value flags
+type tagFlags map[string]string
+
+func (t tagFlags) String() string {
+	pairs := make([]string, 0, len(t))
+	for k, v := range t {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (t tagFlags) Set(value string) error {
+	k, v, ok := strings.Cut(value, "=")
+	k = strings.TrimSpace(k)
+	if !ok || k == "" {
+		return fmt.Errorf("invalid tag '%s' (expected key=value)", value)
+	}
+	if _, exists := t[k]; exists {
+		return fmt.Errorf("duplicate tag key '%s'", k)
+	}
+	t[k] = v
+	return nil
+}
+
+// binaryKeyFlags collects repeatable --binary-key path=file flags
+type binaryKeyFlags map[string]string
+
+func (b binaryKeyFlags) String() string {
+	pairs := make([]string, 0, len(b))
+	for path, file := range b {
+		pairs = append(pairs, path+"="+file)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (b binaryKeyFlags) Set(value string) error {
+	path, file, ok := strings.Cut(value, "=")
+	path = strings.TrimSpace(path)
+	if !ok || path == "" || file == "" {
+		return fmt.Errorf("invalid binary key '%s' (expected path=file)", value)
+	}
+	if _, exists := b[path]; exists {
+		return fmt.Errorf("duplicate binary key '%s'", path)
+	}
+	b[path] = file
+	return nil
+}
+
+// regionFlags collects repeatable --replica-region flags
+type regionFlags []string
+
+func (r *regionFlags) String() string {
+	return strings.Join(*r, ",")
+}
+
+func (r *regionFlags) Set(value string) error {
+	value = strings.TrimSpace(value)
+	if !regionPattern.MatchString(value) {
+		return fmt.Errorf("invalid region '%s' (expected e.g. 'us-west-2')", value)
+	}
+	for _, existing := range *r {
+		if existing == value {
+			return fmt.Errorf("duplicate region '%s'", value)
+		}
+	}
+	*r = append(*r, value)
+	return nil
+}
+
+// pinFlags collects repeatable --pin key=partNumber flags
+type pinFlags map[string]int
+
+func (p pinFlags) String() string {
+	pairs := make([]string, 0, len(p))
+	for k, n := range p {
+		pairs = append(pairs, fmt.Sprintf("%s=%d", k, n))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (p pinFlags) Set(value string) error {
+	// Split at the last '=' so a key may itself contain '='
+	i := strings.LastIndex(value, "=")
+	if i <= 0 {
+		return fmt.Errorf("invalid pin '%s' (expected key=partNumber)", value)
+	}
+	k := value[:i]
+	n, err := strconv.Atoi(strings.TrimSpace(value[i+1:]))
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid pin '%s': part number must be a non-negative integer", value)
+	}
+	if _, exists := p[k]; exists {
+		return fmt.Errorf("duplicate pin for key '%s'", k)
+	}
+	p[k] = n
+	return nil
+}
+
+// maxPart returns the highest pinned part number, or -1 without pins
+func (p pinFlags) maxPart() int {
+	highest := -1
+	for _, n := range p {
+		highest = max(highest, n)
+	}
+	return highest
+}
+
+// checkNumbering rejects pins while the parts of base have a gap in their numbers
+// Keys are pinned to a position in part order, which is the part number only when no number is missing
+func (p pinFlags) checkNumbering(naming multipart.Naming, base string, numbers []int) error {
+	if len(p) == 0 {
+		return nil
+	}
+	sorted := slices.Sorted(slices.Values(numbers))
+	for i, n := range sorted {
+		if n != i {
+			return multipart.WithCode(multipart.CodeUsage, "", fmt.Errorf("--pin needs the parts of '%s' numbered without gaps, but '%s' is missing", base, naming.PartName(base, i)))
+		}
+	}
+	return nil
+}
+
+// partFlags collects the part numbers given with --parts, comma-separated or repeated
+type partFlags []int
+
+func (p *partFlags) String() string {
+	numbers := make([]string, 0, len(*p))
+	for _, n := range *p {
+		numbers = append(numbers, strconv.Itoa(n))
+	}
+	return strings.Join(numbers, ",")
+}
+
+func (p *partFlags) Set(value string) error {
+	for _, field := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid part '%s': part number must be a non-negative integer", field)
+		}
+		if slices.Contains(*p, n) {
+			return fmt.Errorf("duplicate part %d", n)
+		}
+		*p = append(*p, n)
+	}
+	return nil
+}
+
+// maxPart returns the highest part number given, or -1 without --parts
+func (p partFlags) maxPart() int {
+	highest := -1
+	for _, n := range p {
+		highest = max(highest, n)
+	}
+	return highest
 }
 
-func addKeyValues(all map[string]interface{}, new map[string]interface{}, forceUpdate bool) error {
-	for k := range new {
-		_, exists := all[k]
-		if forceUpdate {
-			if !exists {
-				return fmt.Errorf("key '%s' not found for update (use without --force_update to add new keys)", k)
+// validateNestedJSON checks that every string value that looks like escaped JSON
+// (starts with '{' or '[') actually parses, recursing into nested objects and arrays
+func validateNestedJSON(value interface{}, path string) error {
+	switch v := value.(type) {
+	case string:
+		trimmed := strings.TrimSpace(v)
+		if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+			var parsed interface{}
+			if err := json.Unmarshal([]byte(trimmed), &parsed); err != nil {
+				return multipart.WithCode(multipart.CodeInvalidJSON, path, fmt.Errorf("value of key '%s' looks like JSON but is invalid: %w", path, err))
 			}
-			fmt.Printf("Overwriting key '%s'\n", k)
-		} else {
-			if exists {
-				return fmt.Errorf("key '%s' already exists (use --force_update to update existing keys)", k)
+		}
+	case map[string]interface{}:
+		for k, nested := range v {
+			nestedPath := k
+			if path != "" {
+				nestedPath = path + "." + k
+			}
+			if err := validateNestedJSON(nested, nestedPath); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for i, nested := range v {
+			if err := validateNestedJSON(nested, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
 			}
 		}
-	}
-	for k, v := range new {
-		all[k] = v
 	}
 	return nil
 }
 
-// parseJSONInput parses JSON input and preserves the original structure.
-// Objects, arrays, strings etc. are kept in their native types.
-func parseJSONInput(jsonData string) (map[string]interface{}, error) {
-	// Validate JSON syntax and unmarshal into map[string]interface{}
-	var rawData map[string]interface{}
-	if err := json.Unmarshal([]byte(jsonData), &rawData); err != nil {
-		return nil, fmt.Errorf("invalid JSON data: %w", err)
+// readJSONFile reads the raw JSON payload from a file on disk.
+// The content is returned unparsed so it can go through ParseJSONInput like inline data.
+func readJSONFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("JSON file '%s' does not exist", path)
+		}
+		if errors.Is(err, fs.ErrPermission) {
+			return "", fmt.Errorf("permission denied reading JSON file '%s'", path)
+		}
+		return "", fmt.Errorf("failed to read JSON file '%s': %w", path, err)
+	}
+	return string(content), nil
+}
+
+// readEncryptionKey reads the base64 encoded AES key (16, 24 or 32 bytes) stored in the file at path
+func readEncryptionKey(path string) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption key file '%s': %w", path, err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, fmt.Errorf("encryption key file '%s' must contain a base64 encoded key: %w", path, err)
+	}
+	if n := len(key); n != 16 && n != 24 && n != 32 {
+		return nil, fmt.Errorf("encryption key in '%s' is %d bytes, expected 16, 24 or 32 (AES-128, AES-192 or AES-256)", path, n)
+	}
+	return key, nil
+}
+
+// loadSchema reads and compiles the JSON Schema at path
+func loadSchema(path string) (*jsonschema.Schema, error) {
+	content, err := readJSONFile(path)
+	if err != nil {
+		return nil, err
+	}
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(path, strings.NewReader(content)); err != nil {
+		return nil, fmt.Errorf("invalid JSON schema '%s': %w", path, err)
 	}
+	schema, err := compiler.Compile(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON schema '%s': %w", path, err)
+	}
+	return schema, nil
+}
 
-	if len(rawData) == 0 {
-		return nil, fmt.Errorf("JSON data is empty")
+// validateSchema checks the merged data against schema and lists every violation in the error
+func validateSchema(schema *jsonschema.Schema, data map[string]interface{}) error {
+	err := schema.Validate(data)
+	var verr *jsonschema.ValidationError
+	if errors.As(err, &verr) {
+		return multipart.WithCode(multipart.CodeSchemaViolation, "", fmt.Errorf("merged secret data does not match the schema:\n  %s", strings.Join(schemaViolations(verr, nil), "\n  ")))
 	}
+	return err
+}
 
-	// Return the data as-is (no conversion to strings)
-	return rawData, nil
+// schemaViolations flattens a validation error into "location: message" lines for its leaf causes
+func schemaViolations(verr *jsonschema.ValidationError, lines []string) []string {
+	if len(verr.Causes) == 0 {
+		location := verr.InstanceLocation
+		if location == "" {
+			location = "/"
+		}
+		return append(lines, fmt.Sprintf("%s: %s", location, verr.Message))
+	}
+	for _, cause := range verr.Causes {
+		lines = schemaViolations(cause, lines)
+	}
+	return lines
 }
 
-func chunkDataIntoSecrets(data map[string]interface{}) ([]map[string]interface{}, error) {
-	// Extract and sort keys to ensure deterministic chunking
+// warnDominantKeys warns on w about every top-level key whose serialized size exceeds
+// threshold (a fraction of opts.MaxSize); such keys end up isolated and pack poorly
+func warnDominantKeys(w io.Writer, data map[string]interface{}, opts multipart.ChunkOptions, threshold float64) error {
 	keys := make([]string, 0, len(data))
 	for k := range data {
 		keys = append(keys, k)
 	}
-	sort.Strings(keys)
-
-	chunks := []map[string]interface{}{}
-	current := make(map[string]interface{})
+	sort.Slice(keys, func(i, j int) bool { return multipart.KeyLess(keys[i], keys[j], opts.KeyOrder) })
 	for _, k := range keys {
-		v := data[k]
-		// Check if this key-value pair alone exceeds the chunk size
-		testSingle := map[string]interface{}{k: v}
-		jsSingle, err := json.MarshalIndent(testSingle, "", "  ")
+		js, err := opts.Marshal(map[string]interface{}{k: data[k]})
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal key '%s': %w", k, err)
-		}
-		if getSecretSize(string(jsSingle)) > MaxSecretSizeBytes {
-			return nil, fmt.Errorf("key '%s' exceeds max chunk size (%d bytes): got %d. This data cannot be stored in secrets manager even as an individual secret as this hits the max limit supported by AWS", k, MaxSecretSizeBytes, getSecretSize(string(jsSingle)))
+			return fmt.Errorf("failed to marshal key '%s': %w", k, err)
 		}
-		// Trial-based size check: test if adding new key would exceed limit
-		test := make(map[string]interface{}) // Create empty temporary map
-		for ck, cv := range current {        // Copy existing chunk into test
-			test[ck] = cv
+		size := multipart.SecretSize(string(js))
+		if float64(size) > threshold*float64(opts.MaxSize) {
+			fmt.Fprintf(w, "WARNING: key '%s' is %d bytes, %d%% of the %d byte part limit; consider moving it to its own base secret\n", k, size, size*100/opts.MaxSize, opts.MaxSize)
 		}
-		test[k] = v                                   // Add the new key-value to test (trial add)
-		js, err := json.MarshalIndent(test, "", "  ") // Convert test map to JSON to measure size
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal chunk with key '%s': %w", k, err)
+	}
+	return nil
+}
+
+// deleteSecretAtPath removes the leaf key addressed by a dot-notation path from the merged data
+// and returns the modified map for re-chunking. Every parent segment must exist and be a map.
+func deleteSecretAtPath(all map[string]interface{}, jsonPath string) (map[string]interface{}, error) {
+	parts := multipart.SplitJSONPath(jsonPath)
+	current, err := multipart.ObjectAtPath(all, jsonPath, parts[:len(parts)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	leaf := parts[len(parts)-1]
+	if _, exists := current[leaf]; !exists {
+		return nil, multipart.WithCode(multipart.CodeKeyNotFound, jsonPath, fmt.Errorf("key '%s' not found in any multipart secret", jsonPath))
+	}
+	delete(current, leaf)
+	fmt.Fprintf(infoOut, "Deleting key '%s'\n", jsonPath)
+	return all, nil
+}
+
+// deleteSecretsUnderPrefix removes the key at the dot-notation prefix together with everything
+// nested under it, and returns the sorted leaf paths that were removed
+func deleteSecretsUnderPrefix(all map[string]interface{}, prefix string) ([]string, error) {
+	prefix, segments := normalizePrefix(prefix)
+	parent := all
+	if len(segments) > 1 {
+		v, ok := valueAtSegments(all, segments[:len(segments)-1])
+		if parent, ok = v.(map[string]interface{}); !ok {
+			return nil, multipart.WithCode(multipart.CodeKeyNotFound, prefix, fmt.Errorf("no keys found under prefix '%s'", prefix))
 		}
-		if getSecretSize(string(js)) > MaxSecretSizeBytes && len(current) > 0 {
-			// Test exceeded limit → save current chunk and start new one with this key
-			chunks = append(chunks, current)
-			current = map[string]interface{}{k: v}
-		} else {
-			// Test fits → actually add the key to current chunk
-			current[k] = v
+	}
+	leaf := segments[len(segments)-1]
+	v, exists := parent[leaf]
+	if !exists {
+		return nil, multipart.WithCode(multipart.CodeKeyNotFound, prefix, fmt.Errorf("no keys found under prefix '%s'", prefix))
+	}
+	removed := []string{prefix}
+	if nested, ok := v.(map[string]interface{}); ok && len(nested) > 0 {
+		removed = collectKeyPaths(nested, prefix, true, nil)
+		sort.Strings(removed)
+	}
+	delete(parent, leaf)
+	for _, path := range removed {
+		fmt.Fprintf(infoOut, "Deleting key '%s'\n", path)
+	}
+	return removed, nil
+}
+
+// setSecretAtPath replaces the value of the existing leaf key addressed by a dot-notation path.
+// Every parent segment must exist and be a map, and the leaf must already exist.
+// With preserveTypes the new value must have the JSON type of the stored one.
+func setSecretAtPath(all map[string]interface{}, jsonPath string, value interface{}, preserveTypes bool) error {
+	parts := multipart.SplitJSONPath(jsonPath)
+	current, err := multipart.ObjectAtPath(all, jsonPath, parts[:len(parts)-1])
+	if err != nil {
+		return err
+	}
+
+	leaf := parts[len(parts)-1]
+	old, exists := current[leaf]
+	if !exists {
+		return multipart.WithCode(multipart.CodeKeyNotFound, jsonPath, fmt.Errorf("key '%s' not found in any multipart secret (use --json_data to add new keys)", jsonPath))
+	}
+	if preserveTypes {
+		if err := multipart.CheckSameType(jsonPath, old, value); err != nil {
+			return err
 		}
 	}
-	if len(current) > 0 {
-		chunks = append(chunks, current)
+	current[leaf] = value
+	fmt.Fprintf(infoOut, "Setting key '%s'\n", jsonPath)
+	return nil
+}
+
+// appendToArray appends value to the existing array addressed by a dot-notation path
+func appendToArray(all map[string]interface{}, jsonPath string, value interface{}) error {
+	parts := multipart.SplitJSONPath(jsonPath)
+	current, err := multipart.ObjectAtPath(all, jsonPath, parts[:len(parts)-1])
+	if err != nil {
+		return err
+	}
+
+	leaf := parts[len(parts)-1]
+	old, exists := current[leaf]
+	if !exists {
+		return multipart.WithCode(multipart.CodeKeyNotFound, jsonPath, fmt.Errorf("key '%s' not found in any multipart secret", jsonPath))
+	}
+	array, ok := old.([]interface{})
+	if !ok {
+		return multipart.WithCode(multipart.CodeNotArray, jsonPath, fmt.Errorf("cannot append to '%s': existing value is %s, not an array", jsonPath, multipart.JSONKind(old)))
 	}
-	return chunks, nil
+	current[leaf] = append(array, value)
+	fmt.Fprintf(infoOut, "Appending to '%s' (%d element(s) before)\n", jsonPath, len(array))
+	return nil
 }
 
-func addSecretToGivenPath(all map[string]interface{}, new map[string]interface{}, jsonPath string, forceUpdate bool) error {
-	parts := strings.Split(jsonPath, ".")
-	current := all
+// findKey returns which of the fetched parts contains the key at fullPath
+// fullPath is dot-notation path like "Db.Cred.Username" or just "username"
+// An empty part name is returned when the key is not found
+func findKey(parts []multipart.SecretPart, fullPath string) string {
+	for _, part := range parts {
+		// Use gjson to check if the path exists
+		if gjson.Get(part.Raw, fullPath).Exists() {
+			return part.Name
+		}
+	}
+	return ""
+}
 
-	// Traverse to the parent of the target key
-	for i := 0; i < len(parts); i++ {
-		key := parts[i]
-		val, exists := current[key]
-		if !exists {
-			return fmt.Errorf("key '%s' in path '%s' does not exist", key, jsonPath)
+// splitPathList splits a --json_path holding several comma-separated paths
+// A comma inside a key is escaped as '\,' and kept escaped for SplitJSONPath and gjson;
+// empty entries and repeated paths are dropped
+func splitPathList(list string) []string {
+	var paths []string
+	var current strings.Builder
+	add := func() {
+		if path := strings.TrimSpace(current.String()); path != "" && !slices.Contains(paths, path) {
+			paths = append(paths, path)
+		}
+		current.Reset()
+	}
+	for i := 0; i < len(list); i++ {
+		switch c := list[i]; {
+		case c == '\\' && i+1 < len(list):
+			current.WriteByte(c)
+			i++
+			current.WriteByte(list[i])
+		case c == ',':
+			add()
+		default:
+			current.WriteByte(c)
 		}
+	}
+	add()
+	return paths
+}
 
-		// If key exists, ensure it's a map
-		nextMap, ok := val.(map[string]interface{})
-		if !ok {
-			return fmt.Errorf("key '%s' in path '%s' is not a map", key, jsonPath)
+// readPathsFile reads the --paths-file list: one dot-notation path per line,
+// ignoring blank lines and lines starting with '#'
+func readPathsFile(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read paths file '%s': %w", path, err)
+	}
+	var paths []string
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || slices.Contains(paths, line) {
+			continue
 		}
-		current = nextMap
+		paths = append(paths, line)
 	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("paths file '%s' lists no paths", path)
+	}
+	return paths, nil
+}
 
-	// Merge new data into the target map
-	for k, v := range new {
-		_, exists := current[k]
-		if forceUpdate {
-			if !exists {
-				return fmt.Errorf("key '%s' not found at path '%s' for update", k, jsonPath)
+// addBinaryKeys reads the file of every --binary-key and stores its base64 encoded content at the
+// dot-notation path in data, creating missing parent objects; a path also set by the JSON input is an error
+func addBinaryKeys(data map[string]interface{}, keys binaryKeyFlags) error {
+	paths := make([]string, 0, len(keys))
+	for path := range keys {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		content, err := os.ReadFile(keys[path])
+		if err != nil {
+			return fmt.Errorf("failed to read binary file '%s' for '%s': %w", keys[path], path, err)
+		}
+		segments := multipart.SplitJSONPath(path)
+		current := data
+		for _, segment := range segments[:len(segments)-1] {
+			if _, exists := current[segment]; !exists {
+				current[segment] = map[string]interface{}{}
 			}
-			fmt.Printf("Overwriting key '%s' at path '%s'\n", k, jsonPath)
-		} else {
-			if exists {
-				return fmt.Errorf("key '%s' already exists at path '%s'", k, jsonPath)
+			nested, ok := current[segment].(map[string]interface{})
+			if !ok {
+				return multipart.WithCode(multipart.CodeNotObject, path, fmt.Errorf("key '%s' in binary key path '%s' is not a map", segment, path))
 			}
+			current = nested
 		}
-		current[k] = v
+		leaf := segments[len(segments)-1]
+		if _, exists := current[leaf]; exists {
+			return multipart.WithCode(multipart.CodeUsage, path, fmt.Errorf("--binary-key '%s' is also set by the JSON input", path))
+		}
+		current[leaf] = multipart.EncodeBinary(content)
 	}
 	return nil
 }
 
-// findKey searches for a key in multipart secrets and returns which one contains it
-// fullPath is dot-notation path like "Db.Cred.Username" or just "username"
-func findKey(ctx context.Context, sm *SecretManager, base string, numbers []int, fullPath string) error {
-	// Build list of secret names to fetch
-	secretNames := make([]string, 0, len(numbers))
-	for _, n := range numbers {
-		if n == 0 {
-			secretNames = append(secretNames, base)
-		} else {
-			secretNames = append(secretNames, fmt.Sprintf("%s-%d", base, n))
+// isKeyPattern reports whether a --json_path contains wildcards and is searched with findKeyPattern
+func isKeyPattern(path string) bool {
+	return strings.ContainsAny(path, "*?")
+}
+
+// findKeyPattern returns the path and part of every key matching pattern, sorted by path
+// Each segment of pattern is matched against one key level: '*' matches any run of characters
+// and '?' a single character within the segment, so "Db.*.Password" finds Db.Primary.Password
+// Values are never returned, so the result is safe for logs
+func findKeyPattern(parts []multipart.SecretPart, pattern string) []valueMatch {
+	segments := multipart.SplitJSONPath(pattern)
+	matches := []valueMatch{}
+	for _, part := range parts {
+		for _, path := range collectPatternMatches(part.Data, "", segments, nil) {
+			matches = append(matches, valueMatch{Path: path, Part: part.Name})
 		}
 	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Path < matches[j].Path })
+	return matches
+}
 
-	// Fetch all secrets in a single batch call
-	secretsData, err := sm.GetSecretsData(ctx, secretNames)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "ERROR: Failed to fetch secrets: %v\n", err)
-		return err
+// findKeyOccurrences returns every key under prefix, at any depth and in every part, whose path
+// ends with the segments of path, sorted by path; a key duplicated across parts is listed once per part
+// so "Cred.Password" reports both Db.Cred.Password and Cache.Cred.Password
+func findKeyOccurrences(parts []multipart.SecretPart, path string, prefix string, prefixSegments []string) []valueMatch {
+	segments := multipart.SplitJSONPath(path)
+	matches := []valueMatch{}
+	for _, part := range parts {
+		start, ok := valueAtSegments(part.Data, prefixSegments)
+		if !ok {
+			continue
+		}
+		for _, p := range collectOccurrences(start, prefix, segments, nil) {
+			matches = append(matches, valueMatch{Path: p, Part: part.Name})
+		}
 	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Path != matches[j].Path {
+			return matches[i].Path < matches[j].Path
+		}
+		return matches[i].Part < matches[j].Part
+	})
+	return matches
+}
 
-	// Search for the key in each secret
-	for _, secretName := range secretNames {
-		secretValue, exists := secretsData[secretName]
-		if !exists {
-			return fmt.Errorf("secret '%s' not found in batch response. ", secretName)
+// collectOccurrences appends the paths under v whose trailing segments match segments,
+// trying every object and array below v as the starting point
+func collectOccurrences(v interface{}, path string, segments []string, paths []string) []string {
+	paths = collectPatternMatches(v, path, segments, paths)
+	join := func(segment string) string {
+		if path == "" {
+			return segment
 		}
+		return path + "." + segment
+	}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, child := range val {
+			paths = collectOccurrences(child, join(multipart.EscapePathSegment(k)), segments, paths)
+		}
+	case []interface{}:
+		for i, child := range val {
+			paths = collectOccurrences(child, join(strconv.Itoa(i)), segments, paths)
+		}
+	}
+	return paths
+}
 
-		// Use gjson to check if the path exists
-		result := gjson.Get(secretValue, fullPath)
-		if result.Exists() {
-			fmt.Printf("✅ Key '%s' found in: %s\n", fullPath, secretName)
-			return nil
+// collectPatternMatches appends the dot-notation paths under v matching the remaining segments
+// Array elements are addressed by their index, as with --find-value
+func collectPatternMatches(v interface{}, path string, segments []string, paths []string) []string {
+	if len(segments) == 0 {
+		return append(paths, path)
+	}
+	join := func(segment string) string {
+		if path == "" {
+			return segment
+		}
+		return path + "." + segment
+	}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, child := range val {
+			if globMatch(segments[0], k) {
+				paths = collectPatternMatches(child, join(multipart.EscapePathSegment(k)), segments[1:], paths)
+			}
+		}
+	case []interface{}:
+		for i, child := range val {
+			if index := strconv.Itoa(i); globMatch(segments[0], index) {
+				paths = collectPatternMatches(child, join(index), segments[1:], paths)
+			}
 		}
 	}
+	return paths
+}
 
-	fmt.Printf("❌ Key '%s' not found\n", fullPath)
-	return nil
+// globMatch reports whether s matches pattern, where '*' matches any run of characters and '?' any one
+func globMatch(pattern, s string) bool {
+	p, r := []rune(pattern), []rune(s)
+	// star and mark remember the last '*' and the input position it was tried at, for backtracking
+	pi, si, star, mark := 0, 0, -1, 0
+	for si < len(r) {
+		switch {
+		case pi < len(p) && (p[pi] == '?' || p[pi] == r[si]):
+			pi++
+			si++
+		case pi < len(p) && p[pi] == '*':
+			star, mark = pi, si
+			pi++
+		case star >= 0:
+			pi = star + 1
+			mark++
+			si = mark
+		default:
+			return false
+		}
+	}
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p)
 }
 
-func main() {
-	env := flag.String("env", "", "The environment (e.g., staging, prod)")
-	secretName := flag.String("secret_name", "", "Base name of the secret")
-	jsonData := flag.String("json_data", "", "JSON data containing key-value pairs to add")
-	jsonPath := flag.String("json_path", "", "Dot notation path for operations (e.g., 'Cred.Db.Username' for find, 'Cred.Db' for add nested). For add/update: path to nested object. For find: full path to key.")
-	forceUpdate := flag.Bool("force_update", false, "Enable update mode. If true, updates existing keys (fails if missing). If false, adds new keys (fails if exists).")
-	findKeyMode := flag.Bool("find-key", false, "Find mode: Search for key specified in --json_path across multipart secrets")
-	flag.Parse()
+// getValue returns the raw value stored at fullPath across multipart secrets
+// Strings are returned unquoted, everything else (numbers, objects, arrays) as raw JSON
+// An encrypted value is decrypted first when key is set
+func getValue(ctx context.Context, sm *multipart.SecretManager, base string, numbers []int, fullPath string, key []byte) (string, error) {
+	secretNames, secretsData, _, err := sm.FetchPartValues(ctx, base, numbers)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch secrets: %w", err)
+	}
 
-	// Validate required flags
-	if *env == "" || *secretName == "" || (*jsonData == "" && !*findKeyMode) || (*jsonData != "" && *findKeyMode) || (*findKeyMode && *jsonPath == "") {
-		if *env == "" || *secretName == "" {
-			fmt.Fprintf(os.Stderr, "ERROR: --env and --secret_name are required\n")
-		} else if *jsonData == "" && !*findKeyMode {
-			fmt.Fprintf(os.Stderr, "ERROR: Either --json_data (for add/update) or --find-key (for find mode) is required\n")
-		} else if *jsonData != "" && *findKeyMode {
-			fmt.Fprintf(os.Stderr, "ERROR: Cannot use both --json_data and --find-key together\n")
-		} else if *findKeyMode && *jsonPath == "" {
-			fmt.Fprintf(os.Stderr, "ERROR: --json_path is required in find-key mode (e.g., 'username' or 'Db.Cred.Username')\n")
+	for _, secretName := range secretNames {
+		result := gjson.Get(secretsData[secretName], fullPath)
+		if result.Exists() {
+			if key != nil && multipart.IsEncrypted(result.String()) {
+				return decryptedValue(result.String(), fullPath, key)
+			}
+			if result.Type == gjson.String {
+				return result.String(), nil
+			}
+			return result.Raw, nil
 		}
-		os.Exit(1)
 	}
+	return "", multipart.WithCode(multipart.CodeKeyNotFound, fullPath, fmt.Errorf("key '%s' not found", fullPath))
+}
+
+// printValue writes a value read with --get-value to out: binary values as their decoded bytes,
+// anything else followed by a newline
+func printValue(out io.Writer, value, path string) error {
+	if !multipart.IsBinary(value) {
+		_, err := fmt.Fprintln(out, value)
+		return err
+	}
+	content, err := multipart.DecodeBinary(value)
+	if err != nil {
+		return fmt.Errorf("failed to decode binary key '%s': %w", path, err)
+	}
+	_, err = out.Write(content)
+	return err
+}
 
-	baseSecretName, err := verifySecretName(*secretName)
+// decryptedValue decrypts an encrypted value and formats it like getValue
+func decryptedValue(sealed, path string, key []byte) (string, error) {
+	value, err := multipart.DecryptValue(sealed, key)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
-		os.Exit(1)
+		return "", multipart.WithCode(multipart.CodeEncryption, path, fmt.Errorf("failed to decrypt key '%s': %w", path, err))
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
 	}
-	cfg, err := config.LoadDefaultConfig(context.Background())
+	js, err := json.Marshal(value)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "ERROR: failed to load AWS config: %v\n", err)
-		os.Exit(1)
+		return "", err
 	}
-	client := secretsmanager.NewFromConfig(cfg)
-	sm := NewSecretManager(client)
+	return string(js), nil
+}
 
-	tags := map[string]string{
-		"temp:env":     *env,
-		"temp:feature": "multipart_secrets",
+// normalizePrefix returns the canonical dot-notation form of a --prefix value and its segments
+// A trailing '.' is ignored, so "Db." and "Db" select the same subtree
+func normalizePrefix(prefix string) (string, []string) {
+	if prefix == "" {
+		return "", nil
+	}
+	segments := multipart.SplitJSONPath(prefix)
+	if len(segments) > 1 && segments[len(segments)-1] == "" {
+		segments = segments[:len(segments)-1]
+	}
+	escaped := make([]string, len(segments))
+	for i, segment := range segments {
+		escaped[i] = multipart.EscapePathSegment(segment)
 	}
+	return strings.Join(escaped, "."), segments
+}
 
-	// Check if base secret exists before proceeding
-	_, err = client.DescribeSecret(context.Background(), &secretsmanager.DescribeSecretInput{
-		SecretId: aws.String(baseSecretName),
-	})
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "ERROR: Base secret '%s' does not exist. Please create the secret first before adding keys.\n", baseSecretName)
-		os.Exit(1)
+// hasPathPrefix reports whether the dot-notation path is prefix itself or lies under it
+func hasPathPrefix(path, prefix string) bool {
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+".")
+}
+
+// collectKeyPaths appends the paths of all keys in data to paths, prefixed by prefix.
+// When recursive is set, nested objects are expanded into dot-notation paths of their keys.
+func collectKeyPaths(data map[string]interface{}, prefix string, recursive bool, paths []string) []string {
+	for k, v := range data {
+		path := multipart.EscapePathSegment(k)
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+		if nested, ok := v.(map[string]interface{}); ok && recursive && len(nested) > 0 {
+			paths = collectKeyPaths(nested, path, recursive, paths)
+			continue
+		}
+		paths = append(paths, path)
 	}
+	return paths
+}
 
-	// Fetch multipart numbers once and reuse for both FetchAllSecretData and RedistributeSecrets
-	numbers, err := sm.GetMultipartNumbers(context.Background(), baseSecretName)
+// listKeys prints every key across multipart secrets along with the part that contains it.
+// With a prefix only the subtree at that path is listed, starting from its direct children.
+// Values are never printed so the output is safe for logs.
+func listKeys(ctx context.Context, out io.Writer, sm *multipart.SecretManager, base string, numbers []int, recursive bool, prefix string) error {
+	parts, err := sm.FetchSecretParts(ctx, base, numbers)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "ERROR: failed to get multipart numbers: %v\n", err)
-		os.Exit(1)
+		return err
 	}
+	prefix, segments := normalizePrefix(prefix)
 
-	// Find-key mode
-	if *findKeyMode {
-		if err := findKey(context.Background(), sm, baseSecretName, numbers, *jsonPath); err != nil {
-			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
-			os.Exit(1)
+	keyPart := make(map[string]string)
+	paths := []string{}
+	for _, part := range parts {
+		var partPaths []string
+		if prefix == "" {
+			partPaths = collectKeyPaths(part.Data, "", recursive, nil)
+		} else if v, ok := valueAtSegments(part.Data, segments); ok {
+			if nested, isMap := v.(map[string]interface{}); isMap && len(nested) > 0 {
+				partPaths = collectKeyPaths(nested, prefix, recursive, nil)
+			} else {
+				partPaths = []string{prefix}
+			}
+		}
+		for _, path := range partPaths {
+			keyPart[path] = part.Name
+			paths = append(paths, path)
 		}
-		os.Exit(0)
 	}
+	sort.Strings(paths)
 
-	newData, err := parseJSONInput(*jsonData)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
-		os.Exit(1)
+	for _, path := range paths {
+		fmt.Fprintf(out, "%s\t%s\n", path, keyPart[path])
 	}
+	return nil
+}
 
-	// It returns combined Map containing all keys from  Multipart secrtes .
-	allData, err := sm.FetchAllSecretData(context.Background(), baseSecretName, numbers)
+// valueAtSegments returns the value reached by following segments through nested objects of data
+func valueAtSegments(data map[string]interface{}, segments []string) (interface{}, bool) {
+	var v interface{} = data
+	for _, segment := range segments {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[segment]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// findValue returns the path and part of every leaf value equal to value, or containing it
+// when contains is set. Only string forms of leaves are compared (numbers and booleans as JSON)
+// and the values themselves are never returned, so the result is safe for logs.
+// With a prefix only the matches under that dot-notation path are returned.
+func findValue(ctx context.Context, sm *multipart.SecretManager, base string, numbers []int, value string, contains bool, prefix string) ([]valueMatch, error) {
+	parts, err := sm.FetchSecretParts(ctx, base, numbers)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "ERROR: failed to fetch existing secret data: %v\n", err)
-		os.Exit(1)
+		return nil, err
+	}
+	match := func(s string) bool { return s == value }
+	if contains {
+		match = func(s string) bool { return strings.Contains(s, value) }
 	}
 
-	if *jsonPath != "" {
-		if err := addSecretToGivenPath(allData, newData, *jsonPath, *forceUpdate); err != nil {
-			fmt.Fprintf(os.Stderr, "ERROR: failed to update nested keys: %v\n", err)
-			os.Exit(1)
+	matches := []valueMatch{}
+	prefix, _ = normalizePrefix(prefix)
+	for _, part := range parts {
+		for _, path := range collectValueMatches(part.Data, "", match, nil) {
+			if !hasPathPrefix(path, prefix) {
+				continue
+			}
+			matches = append(matches, valueMatch{Path: path, Part: part.Name})
 		}
-	} else {
-		if err := addKeyValues(allData, newData, *forceUpdate); err != nil {
-			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
-			os.Exit(1)
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Path < matches[j].Path })
+	return matches, nil
+}
+
+// collectValueMatches appends the dot-notation paths of the leaves under v whose string form
+// satisfies match. Array elements are addressed by their index.
+func collectValueMatches(v interface{}, path string, match func(string) bool, paths []string) []string {
+	join := func(segment string) string {
+		if path == "" {
+			return segment
+		}
+		return path + "." + segment
+	}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, child := range val {
+			paths = collectValueMatches(child, join(multipart.EscapePathSegment(k)), match, paths)
+		}
+	case []interface{}:
+		for i, child := range val {
+			paths = collectValueMatches(child, join(strconv.Itoa(i)), match, paths)
+		}
+	case string:
+		if match(val) {
+			paths = append(paths, path)
+		}
+	case nil:
+	default:
+		if js, err := json.Marshal(val); err == nil && match(string(js)) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// verifyParts audits the multipart set read-only and returns every problem found: gaps in the
+// part numbering, parts that are not valid JSON objects, parts over maxSize bytes and keys
+// duplicated across parts. Values are never included in the problems.
+func verifyParts(ctx context.Context, sm *multipart.SecretManager, base string, numbers []int, maxSize int) ([]string, error) {
+	problems := []string{}
+	sorted := append([]int(nil), numbers...)
+	sort.Ints(sorted)
+	for i, n := range sorted {
+		if n != i {
+			problems = append(problems, fmt.Sprintf("part numbering is not contiguous: expected %s but found %s", sm.Naming.PartName(base, i), sm.Naming.PartName(base, n)))
+			break
 		}
 	}
 
-	chunks, err := chunkDataIntoSecrets(allData)
+	names := make([]string, 0, len(sorted))
+	for _, n := range sorted {
+		names = append(names, sm.Naming.PartName(base, n))
+	}
+	raw, _, err := sm.GetSecretsData(ctx, names)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
-		os.Exit(1)
+		return nil, err
+	}
+	keyParts := make(map[string][]string)
+	for _, name := range names {
+		value, ok := raw[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: missing from the batch response", name))
+			continue
+		}
+		if size := multipart.SecretSize(value); size > maxSize {
+			problems = append(problems, fmt.Sprintf("%s: %d bytes exceeds the %d byte part limit", name, size, maxSize))
+		}
+		var data map[string]interface{}
+		if err := json.Unmarshal([]byte(value), &data); err != nil || data == nil {
+			problems = append(problems, fmt.Sprintf("%s: not a valid JSON object", name))
+			continue
+		}
+		for k := range data {
+			keyParts[k] = append(keyParts[k], name)
+		}
+	}
+
+	keys := make([]string, 0, len(keyParts))
+	for k, parts := range keyParts {
+		if len(parts) > 1 {
+			keys = append(keys, k)
+		}
 	}
-	if err := sm.RedistributeSecrets(context.Background(), baseSecretName, chunks, tags, numbers); err != nil {
-		fmt.Fprintf(os.Stderr, "ERROR: failed to redistribute secrets: %v\n", err)
-		os.Exit(1)
+	sort.Strings(keys)
+	for _, k := range keys {
+		problems = append(problems, fmt.Sprintf("key '%s' is duplicated in %s", k, strings.Join(keyParts[k], ", ")))
 	}
-	fmt.Printf("Add operation completed successfully. Total keys: %d, Total secrets: %d\n", len(allData), len(chunks))
+	return problems, nil
+}
+
+// describeParts returns the AWS-side metadata of every part in ascending part order
+func describeParts(ctx context.Context, sm *multipart.SecretManager, base string, numbers []int) ([]partMetadata, error) {
+	sorted := append([]int(nil), numbers...)
+	sort.Ints(sorted)
+	descs, err := sm.DescribeParts(ctx, base, sorted)
+	if err != nil {
+		return nil, err
+	}
+	parts := make([]partMetadata, 0, len(descs))
+	for _, desc := range descs {
+		tags := make(map[string]string, len(desc.Tags))
+		for _, tag := range desc.Tags {
+			tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
+		parts = append(parts, partMetadata{
+			Name:            aws.ToString(desc.Name),
+			ARN:             aws.ToString(desc.ARN),
+			Description:     aws.ToString(desc.Description),
+			LastChangedDate: desc.LastChangedDate,
+			KmsKeyID:        aws.ToString(desc.KmsKeyId),
+			RotationEnabled: aws.ToBool(desc.RotationEnabled),
+			Tags:            tags,
+		})
+	}
+	return parts, nil
+}
+
+// printDescribe prints one row of metadata per part, followed by a warning when the parts
+// do not all use the same KMS key
+func printDescribe(out io.Writer, parts []partMetadata) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tLAST CHANGED\tKMS KEY\tROTATION\tTAGS\tARN")
+	kmsKeys := make(map[string]bool)
+	for _, part := range parts {
+		changed := "-"
+		if part.LastChangedDate != nil {
+			changed = part.LastChangedDate.UTC().Format(time.RFC3339)
+		}
+		kmsKey := part.KmsKeyID
+		if kmsKey == "" {
+			kmsKey = "aws/secretsmanager"
+		}
+		kmsKeys[kmsKey] = true
+		rotation := "disabled"
+		if part.RotationEnabled {
+			rotation = "enabled"
+		}
+		tags := make([]string, 0, len(part.Tags))
+		for k, v := range part.Tags {
+			tags = append(tags, k+"="+v)
+		}
+		sort.Strings(tags)
+		tagList := strings.Join(tags, ",")
+		if tagList == "" {
+			tagList = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", part.Name, changed, kmsKey, rotation, tagList, part.ARN)
+	}
+	w.Flush()
+	if len(kmsKeys) > 1 {
+		fmt.Fprintf(out, "WARNING: parts use %d different KMS keys\n", len(kmsKeys))
+	}
+}
+
+// countKeys prints the total number of keys and parts, optionally with a per-part breakdown
+func countKeys(ctx context.Context, out io.Writer, sm *multipart.SecretManager, base string, numbers []int, verbose bool) error {
+	parts, err := sm.FetchSecretParts(ctx, base, numbers)
+	if err != nil {
+		return err
+	}
+	allData, err := multipart.MergeSecretParts(parts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "keys=%d parts=%d\n", len(allData), len(parts))
+	if verbose {
+		for _, part := range parts {
+			fmt.Fprintf(out, "  %s keys=%d\n", part.Name, len(part.Data))
+		}
+	}
+	return nil
+}
+
+// exportSecretData writes the merged data of all parts as indented JSON to path ("-" for out)
+// Keys are ordered by order so exports diff cleanly; nothing is written back to AWS
+// Encrypted values are decrypted when key is set. Binary values keep their bin:base64: form:
+// a JSON string cannot hold arbitrary bytes, and the file can be given to --import unchanged
+func exportSecretData(ctx context.Context, out io.Writer, sm *multipart.SecretManager, base string, numbers []int, path string, order string, key []byte) error {
+	allData, err := sm.FetchAllSecretData(ctx, base, numbers)
+	if err != nil {
+		return fmt.Errorf("failed to fetch existing secret data: %w", err)
+	}
+	if key != nil {
+		if err := multipart.DecryptAll(allData, key); err != nil {
+			return err
+		}
+	}
+	js, err := marshalIndentOrdered(allData, order)
+	if err != nil {
+		return fmt.Errorf("failed to marshal secret data: %w", err)
+	}
+	js = append(js, '\n')
+
+	if path == "-" {
+		_, err = out.Write(js)
+		return err
+	}
+	if err := os.WriteFile(path, js, 0o600); err != nil {
+		return fmt.Errorf("failed to write export file '%s': %w", path, err)
+	}
+	fmt.Fprintf(infoOut, "Exported %d keys from %d secret(s) to %s\n", len(allData), len(numbers), path)
+	return nil
+}
+
+// invalidEnvChars matches the characters not allowed in a shell variable name
+var invalidEnvChars = regexp.MustCompile(`[^A-Z0-9_]`)
+
+// envName turns a dot-notation key path into an environment variable name: "Db.Cred" becomes DB_CRED
+func envName(path string) string {
+	name := invalidEnvChars.ReplaceAllString(strings.ToUpper(strings.Join(multipart.SplitJSONPath(path), "_")), "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+// shellQuote single-quotes s for POSIX shells
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// collectEnvVars adds a variable for every key of data to vars. Nested objects are expanded into
+// one variable per leaf when recursive is set and JSON-encoded into a single variable otherwise
+func collectEnvVars(data map[string]interface{}, prefix string, recursive bool, vars map[string]string, paths map[string]string) error {
+	for k, v := range data {
+		path := multipart.EscapePathSegment(k)
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+		if nested, ok := v.(map[string]interface{}); ok && recursive && len(nested) > 0 {
+			if err := collectEnvVars(nested, path, recursive, vars, paths); err != nil {
+				return err
+			}
+			continue
+		}
+		var value string
+		switch val := v.(type) {
+		case string:
+			value = val
+			if multipart.IsBinary(val) {
+				content, err := multipart.DecodeBinary(val)
+				if err != nil {
+					return fmt.Errorf("failed to decode binary key '%s': %w", path, err)
+				}
+				if bytes.IndexByte(content, 0) >= 0 {
+					return fmt.Errorf("binary key '%s' contains NUL bytes, which a shell variable cannot hold; read it with --get-value", path)
+				}
+				value = string(content)
+			}
+		case nil:
+		default:
+			js, err := json.Marshal(val)
+			if err != nil {
+				return fmt.Errorf("failed to marshal key '%s': %w", path, err)
+			}
+			value = string(js)
+		}
+		name := envName(path)
+		if other, exists := paths[name]; exists {
+			return fmt.Errorf("keys '%s' and '%s' both map to environment variable %s", other, path, name)
+		}
+		vars[name], paths[name] = value, path
+	}
+	return nil
+}
+
+// exportEnv prints the merged data as sorted `export NAME='value'` lines for eval in a shell
+// Encrypted values are decrypted when key is set and binary values are decoded
+func exportEnv(ctx context.Context, out io.Writer, sm *multipart.SecretManager, base string, numbers []int, recursive bool, key []byte) error {
+	allData, err := sm.FetchAllSecretData(ctx, base, numbers)
+	if err != nil {
+		return fmt.Errorf("failed to fetch existing secret data: %w", err)
+	}
+	if key != nil {
+		if err := multipart.DecryptAll(allData, key); err != nil {
+			return err
+		}
+	}
+	vars := make(map[string]string, len(allData))
+	if err := collectEnvVars(allData, "", recursive, vars, make(map[string]string, len(allData))); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "export %s=%s\n", name, shellQuote(vars[name]))
+	}
+	return nil
+}
+
+// restoreBackup uploads the exact part values of a backup directory
+// Existing parts that are not part of the backup are only removed with prune, otherwise restore is refused
+func restoreBackup(ctx context.Context, out io.Writer, sm *multipart.SecretManager, dir string, base string, numbers []int, tags map[string]string, dryRun bool, prune bool, prompt prompter) error {
+	manifest, contents, err := loadBackup(dir)
+	if err != nil {
+		return err
+	}
+	if manifest.BaseName != base {
+		return fmt.Errorf("backup in '%s' is for secret '%s', not '%s'", dir, manifest.BaseName, base)
+	}
+
+	existing := make(map[string]bool, len(numbers))
+	for _, n := range numbers {
+		existing[sm.Naming.PartName(base, n)] = true
+	}
+	inBackup := make(map[string]bool, len(manifest.Parts))
+	for _, part := range manifest.Parts {
+		inBackup[part.Name] = true
+	}
+	var extra []int
+	for _, n := range numbers {
+		if !inBackup[sm.Naming.PartName(base, n)] {
+			extra = append(extra, n)
+		}
+	}
+	if len(extra) > 0 && !prune {
+		return multipart.WithCode(multipart.CodeEmptyParts, "", fmt.Errorf("%d existing part(s) are not in the backup and would keep stale keys; use --prune-empty-parts to delete them", len(extra)))
+	}
+	if !dryRun {
+		if err := prompt.confirm(fmt.Sprintf("About to restore %d part(s) and delete %d part(s) of '%s'.", len(manifest.Parts), len(extra), base)); err != nil {
+			return err
+		}
+	}
+
+	if dryRun {
+		fmt.Fprintf(out, "DRY RUN: no changes will be written to AWS\n")
+	}
+	for _, part := range manifest.Parts {
+		action := "CREATE"
+		if existing[part.Name] {
+			action = "UPDATE"
+		}
+		if dryRun {
+			fmt.Fprintf(out, "  would %s %s: %d bytes\n", action, part.Name, part.Bytes)
+			continue
+		}
+		if err := sm.CreateOrModifySecretString(ctx, part.Name, contents[part.Name], tags, ""); err != nil {
+			return fmt.Errorf("failed to restore '%s': %w", part.Name, err)
+		}
+		fmt.Fprintf(infoOut, "Restored %s (%d bytes)\n", part.Name, part.Bytes)
+	}
+	if dryRun {
+		for _, n := range extra {
+			fmt.Fprintf(out, "  would DELETE %s\n", sm.Naming.PartName(base, n))
+		}
+		return nil
+	}
+	if err := sm.DeleteParts(ctx, base, extra); err != nil {
+		return fmt.Errorf("failed to prune parts not in backup: %w", err)
+	}
+	fmt.Fprintf(infoOut, "Restore completed successfully. Total secrets: %d\n", len(manifest.Parts))
+	return nil
+}
+
+// copySecretSet writes the merged data of base, repacked with opts, to the parts of target
+// A target that already holds keys is only overwritten with overwrite; with prune its parts
+// that are no longer needed are deleted
+func copySecretSet(ctx context.Context, out io.Writer, sm *multipart.SecretManager, base string, numbers []int, target string, tags map[string]string, opts multipart.ChunkOptions, maxParts int, overwrite, dryRun, prune bool, prompt prompter) error {
+	allData, err := sm.FetchAllSecretData(ctx, base, numbers)
+	if err != nil {
+		return fmt.Errorf("failed to fetch source secret data: %w", err)
+	}
+	targetNumbers, err := sm.GetMultipartNumbers(ctx, target)
+	if err != nil {
+		return fmt.Errorf("failed to get multipart numbers of '%s': %w", target, err)
+	}
+	targetParts, err := sm.FetchSecretParts(ctx, target, targetNumbers)
+	if err != nil {
+		return fmt.Errorf("failed to fetch target secret data: %w", err)
+	}
+	targetKeys := 0
+	for _, part := range targetParts {
+		targetKeys += len(part.Data)
+	}
+	if targetKeys > 0 && !overwrite {
+		return multipart.WithCode(multipart.CodeTargetExists, "", fmt.Errorf("target '%s' already holds %d key(s); use --force_update to overwrite it", target, targetKeys))
+	}
+
+	if err := pinFlags(opts.Pins).checkNumbering(sm.Naming, target, targetNumbers); err != nil {
+		return err
+	}
+	chunks, err := multipart.ChunkDataIntoSecrets(allData, opts)
+	if err != nil {
+		return err
+	}
+	if err := multipart.CheckChunks(allData, chunks); err != nil {
+		return err
+	}
+	writeNumbers, pruneNumbers := targetNumbers, []int(nil)
+	if prune {
+		writeNumbers, pruneNumbers = multipart.SplitPrunableParts(targetNumbers, len(chunks))
+	}
+	parts, err := summarizeParts(sm.Naming, target, chunks, writeNumbers, maxParts, opts)
+	if err != nil {
+		return err
+	}
+	pruned := make([]string, 0, len(pruneNumbers))
+	for _, n := range pruneNumbers {
+		pruned = append(pruned, sm.Naming.PartName(target, n))
+	}
+	totals := fmt.Sprintf("Total keys: %d, Total secrets: %d", len(allData), len(chunks))
+	if dryRun {
+		printDryRun(out, parts, pruned, nil)
+		fmt.Fprintf(out, "Copy dry run completed. %s\n", totals)
+		return nil
+	}
+	if err := prompt.confirm(fmt.Sprintf("About to copy '%s' to %d part(s) and delete %d part(s) of '%s'.", base, len(parts), len(pruned), target)); err != nil {
+		return err
+	}
+
+	versions := make(map[string]string, len(targetParts))
+	previous := make(map[string]string, len(targetParts))
+	for _, part := range targetParts {
+		versions[part.Name] = part.VersionID
+		previous[part.Name] = part.Raw
+	}
+	if err := sm.RedistributeSecrets(ctx, target, chunks, tags, writeNumbers, versions, previous); err != nil {
+		return fmt.Errorf("failed to write copy: %w", err)
+	}
+	if err := sm.DeleteParts(ctx, target, pruneNumbers); err != nil {
+		return fmt.Errorf("failed to prune unused parts: %w", err)
+	}
+	fmt.Fprintf(infoOut, "Copy to '%s' completed successfully. %s\n", target, totals)
+	return nil
+}
+
+// printDuplicateReport prints every key found in more than one part and which part's value is kept
+func printDuplicateReport(duplicates map[string][]string, policy string) {
+	if len(duplicates) == 0 {
+		fmt.Fprintf(infoOut, "No duplicate keys found across parts\n")
+		return
+	}
+	keys := make([]string, 0, len(duplicates))
+	for k := range duplicates {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(infoOut, "Found %d duplicate key(s) across parts (%s-writer-wins):\n", len(keys), policy)
+	for _, k := range keys {
+		names := duplicates[k]
+		kept := names[0]
+		if policy == multipart.DuplicateLastWins {
+			kept = names[len(names)-1]
+		}
+		fmt.Fprintf(infoOut, "  %s: %s (keeping %s)\n", k, strings.Join(names, ", "), kept)
+	}
+}
+
+// partsUnchanged reports whether every chunk would be written exactly as its part is stored,
+// so a --normalize of an already normalized set writes nothing
+func partsUnchanged(parts []partSummary, chunks []map[string]interface{}, existing []multipart.SecretPart, opts multipart.ChunkOptions) (bool, error) {
+	stored := make(map[string]string, len(existing))
+	for _, part := range existing {
+		stored[part.Name] = part.Raw
+	}
+	if len(parts) != len(stored) {
+		return false, nil
+	}
+	for i, part := range parts {
+		raw, ok := stored[part.Name]
+		if !ok {
+			return false, nil
+		}
+		js, err := opts.Marshal(chunks[i])
+		if err != nil {
+			return false, fmt.Errorf("failed to marshal part '%s': %w", part.Name, err)
+		}
+		if string(js) != raw {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// localizedLayout lays allData out over the existing parts named in names (in part order), keeping
+// every stored key in the part holding it. Keys that are new go to the part that already changes,
+// or to the last part with room when none does. It returns the layout and the index of the only
+// part whose content changes (-1 when none does); ok is false when the change spans several parts,
+// a key is stored twice or a part would end up empty or over opts.MaxSize, so everything is repacked
+func localizedLayout(names []string, existing []multipart.SecretPart, allData map[string]interface{}, opts multipart.ChunkOptions) ([]map[string]interface{}, int, bool, error) {
+	byName := make(map[string]multipart.SecretPart, len(existing))
+	for _, part := range existing {
+		byName[part.Name] = part
+	}
+	if len(byName) != len(names) {
+		return nil, -1, false, nil
+	}
+	marshal := func(i int, chunk map[string]interface{}) (string, error) {
+		js, err := opts.Marshal(chunk)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal part '%s': %w", names[i], err)
+		}
+		return string(js), nil
+	}
+
+	layout := make([]map[string]interface{}, len(names))
+	placed := make(map[string]bool, len(allData))
+	changed := -1
+	for i, name := range names {
+		part, ok := byName[name]
+		if !ok {
+			return nil, -1, false, nil
+		}
+		chunk := make(map[string]interface{}, len(part.Data))
+		for k := range part.Data {
+			if placed[k] {
+				return nil, -1, false, nil
+			}
+			placed[k] = true
+			if v, ok := allData[k]; ok {
+				chunk[k] = v
+			}
+		}
+		layout[i] = chunk
+		js, err := marshal(i, chunk)
+		if err != nil {
+			return nil, -1, false, err
+		}
+		if js == part.Raw {
+			continue
+		}
+		if changed >= 0 {
+			return nil, -1, false, nil
+		}
+		changed = i
+	}
+
+	var added []string
+	for k := range allData {
+		if !placed[k] {
+			added = append(added, k)
+		}
+	}
+	candidates := []int{changed}
+	if changed < 0 && len(added) > 0 {
+		candidates = candidates[:0]
+		for i := len(names) - 1; i >= 0; i-- {
+			candidates = append(candidates, i)
+		}
+	}
+	for _, i := range candidates {
+		if i < 0 {
+			return layout, -1, true, nil
+		}
+		chunk := make(map[string]interface{}, len(layout[i])+len(added))
+		for k, v := range layout[i] {
+			chunk[k] = v
+		}
+		for _, k := range added {
+			chunk[k] = allData[k]
+		}
+		js, err := marshal(i, chunk)
+		if err != nil {
+			return nil, -1, false, err
+		}
+		if len(chunk) > 0 && multipart.SecretSize(js) <= opts.MaxSize {
+			layout[i] = chunk
+			return layout, i, true, nil
+		}
+	}
+	return nil, -1, false, nil
+}
+
+// emptyPartsError refuses to write the chunks of an operation that left fewer chunks than parts
+// done describes the operation, e.g. "after deleting 'a.b'", and key is the key it was given for, if any
+func emptyPartsError(key, done string, chunks, parts int) error {
+	return multipart.WithCode(multipart.CodeEmptyParts, key, fmt.Errorf("%s the keys fit in %d secret(s) but %d multipart secrets exist. Refusing to write empty parts; use --prune-empty-parts or manually delete the extra secrets", done, chunks, parts))
+}
+
+// lockRetryInterval is how long acquireLock waits between attempts while the lock is held
+const lockRetryInterval = 2 * time.Second
+
+// acquireLock takes the lock of base, waiting while another run holds it until ctx is done
+func acquireLock(ctx context.Context, out io.Writer, sm *multipart.SecretManager, base string, ttl time.Duration) (*multipart.Lock, error) {
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("%s on %s (pid %d)", localIdentity(), host, os.Getpid())
+	waiting := false
+	for {
+		lock, err := sm.TryLock(ctx, base, owner, ttl)
+		var held *multipart.LockHeldError
+		if !errors.As(err, &held) {
+			return lock, err
+		}
+		if !waiting {
+			fmt.Fprintf(out, "Waiting: %v\n", held)
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, multipart.WithCode(multipart.CodeConcurrentModification, "", fmt.Errorf("gave up waiting: %w", held))
+		case <-time.After(lockRetryInterval):
+		}
+	}
+}
+
+// prompter asks for confirmation on the streams run was given
+type prompter struct {
+	in  io.Reader
+	out io.Writer
+	// assumeYes skips the prompt
+	assumeYes bool
+}
+
+// confirm asks the user to approve a destructive action
+// Without assumeYes a stdin that is a file but not a terminal is refused instead of blocking;
+// other readers (e.g. in tests) are read for the answer
+func (p prompter) confirm(prompt string) error {
+	if p.assumeYes {
+		return nil
+	}
+	if f, ok := p.in.(*os.File); ok {
+		info, err := f.Stat()
+		if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+			return fmt.Errorf("stdin is not a terminal; pass --yes to confirm without prompting")
+		}
+	}
+	fmt.Fprintf(p.out, "%s Proceed? [y/N] ", prompt)
+	answer, _ := bufio.NewReader(p.in).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return fmt.Errorf("aborted by user")
+}
+
+// printDryRun prints the part layout a redistribution would produce without writing anything,
+// followed by the planned API calls when plan is not empty.
+func printDryRun(out io.Writer, parts []partSummary, pruned []string, plan []apiCall) {
+	fmt.Fprintf(out, "DRY RUN: no changes will be written to AWS\n")
+	for _, part := range parts {
+		fmt.Fprintf(out, "  would %s %s: %d keys, %s\n", strings.ToUpper(part.Action), part.Name, part.Keys, part.sizeUsage())
+	}
+	for _, name := range pruned {
+		fmt.Fprintf(out, "  would DELETE %s\n", name)
+	}
+	if len(plan) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "Planned API calls:\n")
+	for i, call := range plan {
+		line := fmt.Sprintf("  %d. %s", i+1, call.Operation)
+		if call.SecretID != "" {
+			line += " " + call.SecretID
+		}
+		if call.Bytes > 0 {
+			line += fmt.Sprintf(" (%d bytes)", call.Bytes)
+		}
+		if call.Note != "" {
+			line += " [" + call.Note + "]"
+		}
+		fmt.Fprintln(out, line)
+	}
+}
+
+// validEndpointURL reports whether raw is an absolute http(s) URL usable as --endpoint-url
+func validEndpointURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// invocation holds what every mode handler shares once run has parsed the flags and checked
+// the base secret
+type invocation struct {
+	ctx     context.Context
+	sm      *multipart.SecretManager
+	base    string
+	numbers []int
+	stdout  io.Writer
+	stderr  io.Writer
+	// summaryOut receives the human readable results that --quiet suppresses
+	summaryOut  io.Writer
+	jsonOutput  bool
+	quiet       bool
+	timer       *phaseTimer
+	writeResult func(v interface{}) error
+	fail        func(err error) int
+}
+
+// exit returns the exit code of a handler that only reports err
+func (inv *invocation) exit(err error) int {
+	if err != nil {
+		return inv.fail(err)
+	}
+	return 0
+}
+
+// runJSONPath lists the keys the --jsonpath query selects, or prints their values with --get-value
+func runJSONPath(inv *invocation, expr *jsonpath.Path, query string, values bool, key []byte) int {
+	parts, err := inv.sm.FetchSecretParts(inv.ctx, inv.base, inv.numbers)
+	if err != nil {
+		return inv.fail(fmt.Errorf("failed to fetch secrets: %w", err))
+	}
+	matches, err := selectJSONPath(parts, expr)
+	if err != nil {
+		return inv.fail(err)
+	}
+	if values {
+		if len(matches) == 0 {
+			return inv.fail(multipart.WithCode(multipart.CodeKeyNotFound, "", fmt.Errorf("no value matches --jsonpath '%s'", query)))
+		}
+		for _, m := range matches {
+			value, err := formatJSONPathValue(m, key)
+			if err != nil {
+				return inv.fail(err)
+			}
+			if err := printValue(inv.stdout, value, m.Path); err != nil {
+				return inv.fail(err)
+			}
+		}
+		return 0
+	}
+	result := findPatternResult{Operation: "find", Pattern: query, Found: len(matches) > 0, Matches: []valueMatch{}}
+	for _, m := range matches {
+		result.Matches = append(result.Matches, valueMatch{Path: m.Path, Part: m.Part})
+	}
+	switch {
+	case inv.jsonOutput:
+		if err := inv.writeResult(result); err != nil {
+			return inv.fail(err)
+		}
+	case len(matches) == 0:
+		fmt.Fprintf(inv.summaryOut, "❌ No key matches '%s'\n", query)
+	default:
+		for _, m := range result.Matches {
+			fmt.Fprintf(inv.summaryOut, "✅ Key '%s' found in: %s\n", m.Path, m.Part)
+		}
+	}
+	if len(matches) == 0 && inv.quiet {
+		return exitNotFound
+	}
+	return 0
+}
+
+// runFindKey reports the part holding each of paths, listing every match of a pattern or,
+// with allOccurrences, of a key name at any depth
+func runFindKey(inv *invocation, paths []string, keyPrefix string, allOccurrences bool) int {
+	parts, err := inv.sm.FetchSecretParts(inv.ctx, inv.base, inv.numbers)
+	if err != nil {
+		return inv.fail(fmt.Errorf("failed to fetch secrets: %w", err))
+	}
+	prefix, prefixSegments := normalizePrefix(keyPrefix)
+	lines := inv.summaryOut
+	if inv.jsonOutput {
+		lines = io.Discard
+	}
+	results := make([]interface{}, 0, len(paths))
+	allFound := true
+	for _, findPath := range paths {
+		query := findPath
+		if prefix != "" {
+			findPath = prefix + "." + findPath
+		}
+		if allOccurrences || isKeyPattern(findPath) {
+			var matches []valueMatch
+			if allOccurrences {
+				matches = findKeyOccurrences(parts, query, prefix, prefixSegments)
+			} else {
+				matches = findKeyPattern(parts, findPath)
+			}
+			results = append(results, findPatternResult{Pattern: findPath, Found: len(matches) > 0, Matches: matches})
+			allFound = allFound && len(matches) > 0
+			if len(matches) == 0 {
+				fmt.Fprintf(lines, "❌ No key matches '%s'\n", findPath)
+			}
+			for _, m := range matches {
+				fmt.Fprintf(lines, "✅ Key '%s' found in: %s\n", m.Path, m.Part)
+			}
+			if allOccurrences && len(matches) > 1 {
+				fmt.Fprintf(lines, "WARNING: '%s' occurs %d times; address the intended key by its full path\n", query, len(matches))
+			}
+			continue
+		}
+		result := findResult{Path: findPath}
+		if part := findKey(parts, findPath); part != "" {
+			result.Found, result.Part = true, &part
+			fmt.Fprintf(lines, "✅ Key '%s' found in: %s\n", findPath, part)
+		} else {
+			fmt.Fprintf(lines, "❌ Key '%s' not found\n", findPath)
+		}
+		results = append(results, result)
+		allFound = allFound && result.Found
+	}
+	if inv.jsonOutput {
+		// A single path keeps its own result object; several are wrapped in one list
+		var out interface{} = findBatchResult{Operation: "find", Found: allFound, Results: results}
+		if len(results) == 1 {
+			switch r := results[0].(type) {
+			case findResult:
+				r.Operation = "find"
+				out = r
+			case findPatternResult:
+				r.Operation = "find"
+				out = r
+			}
+		}
+		if err := inv.writeResult(out); err != nil {
+			return inv.fail(err)
+		}
+	}
+	if !allFound && inv.quiet {
+		return exitNotFound
+	}
+	return 0
+}
+
+// runFindValue reports every key whose value equals value, or contains it when contains is set
+func runFindValue(inv *invocation, value string, contains bool, prefix string) int {
+	matches, err := findValue(inv.ctx, inv.sm, inv.base, inv.numbers, value, contains, prefix)
+	if err != nil {
+		return inv.fail(err)
+	}
+	switch {
+	case inv.jsonOutput:
+		if err := inv.writeResult(findValueResult{Operation: "find-value", Found: len(matches) > 0, Matches: matches}); err != nil {
+			return inv.fail(err)
+		}
+	case len(matches) == 0:
+		fmt.Fprintf(inv.summaryOut, "❌ Value not found\n")
+		if inv.quiet {
+			return exitNotFound
+		}
+	default:
+		for _, m := range matches {
+			fmt.Fprintf(inv.summaryOut, "✅ Value found at '%s' in: %s\n", m.Path, m.Part)
+		}
+	}
+	return 0
+}
+
+// runGetValue prints the value stored at path
+func runGetValue(inv *invocation, path string, key []byte) int {
+	value, err := getValue(inv.ctx, inv.sm, inv.base, inv.numbers, path, key)
+	if err != nil {
+		return inv.fail(err)
+	}
+	return inv.exit(printValue(inv.stdout, value, path))
+}
+
+// runVerify checks every part of the set and fails when any of them has a problem
+func runVerify(inv *invocation, maxSize int) int {
+	problems, err := verifyParts(inv.ctx, inv.sm, inv.base, inv.numbers, maxSize)
+	if err != nil {
+		return inv.fail(err)
+	}
+	if inv.jsonOutput {
+		if err := inv.writeResult(verifyResult{Operation: "verify", Healthy: len(problems) == 0, Parts: len(inv.numbers), Problems: problems}); err != nil {
+			return inv.fail(err)
+		}
+	} else {
+		for _, problem := range problems {
+			fmt.Fprintf(inv.stdout, "❌ %s\n", problem)
+		}
+		if len(problems) == 0 {
+			fmt.Fprintf(inv.summaryOut, "✅ '%s' is healthy: %d part(s) checked\n", inv.base, len(inv.numbers))
+		}
+	}
+	if len(problems) > 0 {
+		return exitFailure
+	}
+	return 0
+}
+
+// runDescribe prints the metadata of every part
+func runDescribe(inv *invocation) int {
+	parts, err := describeParts(inv.ctx, inv.sm, inv.base, inv.numbers)
+	if err != nil {
+		return inv.fail(err)
+	}
+	if inv.jsonOutput {
+		return inv.exit(inv.writeResult(describeResult{Operation: "describe", Parts: parts}))
+	}
+	printDescribe(inv.stdout, parts)
+	return 0
+}
+
+// readInput returns the JSON document a write reads from stdin, from file or given inline
+func readInput(stdin io.Reader, fromStdin bool, file, inline string) (string, error) {
+	switch {
+	case fromStdin:
+		content, err := io.ReadAll(stdin)
+		if err != nil {
+			return "", fmt.Errorf("failed to read JSON data from stdin: %w", err)
+		}
+		return string(content), nil
+	case file != "":
+		return readJSONFile(file)
+	}
+	return inline, nil
+}
+
+// edit is the change one write mode makes to the merged secret data; runWrite does the rest
+type edit struct {
+	operation string
+	// shrinking describes an edit that can leave fewer chunks than parts, for emptyPartsError
+	shrinking, shrinkingKey string
+	// replace discards the stored keys instead of merging them
+	replace bool
+	// repack keeps every key and only changes the layout
+	repack bool
+	// apply returns the changed data and the keys it deleted; changed is false when there is
+	// nothing to write
+	apply func(all map[string]interface{}) (data map[string]interface{}, deleted []string, changed bool, err error)
+}
+
+// importEdit replaces every stored key with data
+func importEdit(data map[string]interface{}) *edit {
+	return &edit{operation: "Import", replace: true, apply: func(map[string]interface{}) (map[string]interface{}, []string, bool, error) {
+		return data, nil, true, nil
+	}}
+}
+
+// setEdit stores value at path
+func setEdit(path, value string, preserveTypes bool) *edit {
+	return &edit{operation: "Set", shrinking: fmt.Sprintf("after setting '%s'", path), shrinkingKey: path, apply: func(all map[string]interface{}) (map[string]interface{}, []string, bool, error) {
+		return all, nil, true, setSecretAtPath(all, path, value, preserveTypes)
+	}}
+}
+
+// appendEdit appends value to the array at path
+func appendEdit(path, value string) *edit {
+	return &edit{operation: "Append", apply: func(all map[string]interface{}) (map[string]interface{}, []string, bool, error) {
+		return all, nil, true, appendToArray(all, path, value)
+	}}
+}
+
+// deleteKeysEdit deletes each of paths
+func deleteKeysEdit(paths []string) *edit {
+	return &edit{operation: "Delete", shrinking: fmt.Sprintf("after deleting '%s'", strings.Join(paths, "', '")), shrinkingKey: paths[0], apply: func(all map[string]interface{}) (map[string]interface{}, []string, bool, error) {
+		for _, path := range paths {
+			var err error
+			if all, err = deleteSecretAtPath(all, path); err != nil {
+				return nil, nil, false, err
+			}
+		}
+		if len(paths) > 1 {
+			return all, paths, true, nil
+		}
+		return all, nil, true, nil
+	}}
+}
+
+// deletePrefixEdit deletes every key under prefix
+func deletePrefixEdit(prefix string) *edit {
+	return &edit{operation: "Delete", shrinking: fmt.Sprintf("after deleting the keys under '%s'", prefix), shrinkingKey: prefix, apply: func(all map[string]interface{}) (map[string]interface{}, []string, bool, error) {
+		deleted, err := deleteSecretsUnderPrefix(all, prefix)
+		return all, deleted, true, err
+	}}
+}
+
+// normalizeEdit repacks every key as it is
+func normalizeEdit() *edit {
+	return &edit{operation: "Normalize", shrinking: "repacked,", repack: true, apply: func(all map[string]interface{}) (map[string]interface{}, []string, bool, error) {
+		return all, nil, true, nil
+	}}
+}
+
+// mergePatchEdit applies the RFC 7386 merge patch
+func mergePatchEdit(patch map[string]interface{}) *edit {
+	return &edit{operation: "Patch", shrinking: "after applying the merge patch", apply: func(all map[string]interface{}) (map[string]interface{}, []string, bool, error) {
+		set, deleted := multipart.ApplyMergePatch(all, patch)
+		if set == 0 && len(deleted) == 0 {
+			fmt.Fprintf(infoOut, "The merge patch changes nothing, nothing to write\n")
+			return all, nil, false, nil
+		}
+		fmt.Fprintf(infoOut, "Patching: %d value(s) set, %d key(s) removed\n", set, len(deleted))
+		return all, deleted, true, nil
+	}}
+}
+
+// addEdit adds the keys of data at jsonPath, or at the root when it is empty. With dottedKeys,
+// keys containing '.' are added at the nested path they name
+func addEdit(data map[string]interface{}, jsonPath string, dottedKeys bool, opts multipart.AddOptions) *edit {
+	return &edit{operation: "Add", apply: func(all map[string]interface{}) (map[string]interface{}, []string, bool, error) {
+		var changed int
+		var err error
+		rootData, nestedData := data, map[string]map[string]interface{}(nil)
+		if dottedKeys {
+			rootData, nestedData, err = multipart.SplitDottedKeys(data)
+			if err != nil {
+				return nil, nil, false, err
+			}
+		}
+		if jsonPath != "" {
+			changed, err = multipart.AddSecretToGivenPath(all, rootData, jsonPath, opts)
+			if err != nil {
+				return nil, nil, false, fmt.Errorf("failed to update nested keys: %w", err)
+			}
+		} else if len(rootData) > 0 {
+			changed, err = multipart.AddKeyValues(all, rootData, opts)
+			if err != nil {
+				return nil, nil, false, err
+			}
+		}
+		nestedPaths := make([]string, 0, len(nestedData))
+		for path := range nestedData {
+			nestedPaths = append(nestedPaths, path)
+		}
+		sort.Strings(nestedPaths)
+		for _, path := range nestedPaths {
+			target := path
+			if jsonPath != "" {
+				target = jsonPath + "." + path
+			}
+			n, err := multipart.AddSecretToGivenPath(all, nestedData[path], target, opts)
+			if err != nil {
+				return nil, nil, false, fmt.Errorf("failed to update nested keys: %w", err)
+			}
+			changed += n
+		}
+		// Nothing to write keeps re-runs from creating new versions of every part
+		if opts.SkipExisting && changed == 0 {
+			fmt.Fprintf(infoOut, "All %d key(s) already have the given values, nothing to write\n", len(data))
+			return all, nil, false, nil
+		}
+		return all, nil, true, nil
+	}}
+}
+
+// writeConfig holds the flags the write pipeline reads
+type writeConfig struct {
+	chunkOpts multipart.ChunkOptions
+	maxParts  int
+	maxKeys   int
+	schema    *jsonschema.Schema
+	// encryptPaths lists the keys --encrypt-keys encrypts with encryptionKey
+	encryptionKey []byte
+	encryptPaths  []string
+	// input is the JSON document the write adds at inputPath, for --preserve-order
+	input            string
+	inputPath        string
+	preserveOrder    bool
+	reportDuplicates bool
+	duplicatePolicy  string
+	noMultipart      bool
+	fullRedistribute bool
+	// dominantThreshold is the share of a part above which one key is reported
+	dominantThreshold  float64
+	prune              bool
+	dryRun             bool
+	verbose            bool
+	lockName           string
+	identity           bool
+	prompt             prompter
+	backupDir          string
+	noConcurrencyCheck bool
+	noRollback         bool
+	tags               map[string]string
+}
+
+// runWrite is the pipeline every write mode shares: it fetches and merges the parts, applies e,
+// chunks the result and writes it. A nil e only reports the duplicate keys
+func runWrite(inv *invocation, e *edit, cfg writeConfig) int {
+	ctx, sm, base, numbers, fail := inv.ctx, inv.sm, inv.base, inv.numbers, inv.fail
+	naming := sm.Naming
+	// It returns combined Map containing all keys from  Multipart secrtes .
+	fetchStart := time.Now()
+	existingParts, err := sm.FetchSecretParts(ctx, base, numbers)
+	inv.timer.track("fetch", fetchStart)
+	if err != nil {
+		return fail(fmt.Errorf("failed to fetch existing secret data: %w", err))
+	}
+	var allData map[string]interface{}
+	switch {
+	case e != nil && e.replace:
+		// Existing keys are discarded; the parts are still fetched for backups and version checks
+	case cfg.reportDuplicates:
+		var duplicates map[string][]string
+		allData, duplicates = multipart.MergeSecretPartsLenient(existingParts, cfg.duplicatePolicy)
+		printDuplicateReport(duplicates, cfg.duplicatePolicy)
+		if e == nil {
+			return 0
+		}
+	default:
+		allData, err = multipart.MergeSecretParts(existingParts)
+		if err != nil {
+			return fail(fmt.Errorf("failed to fetch existing secret data: %w", err))
+		}
+	}
+
+	// Values stored encrypted must stay encrypted when a write overwrites them
+	var storedEncrypted []string
+	if !e.replace {
+		storedEncrypted, err = multipart.EncryptedPaths(allData, cfg.encryptionKey)
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	operation := e.operation
+	allData, deleted, changed, err := e.apply(allData)
+	if err != nil {
+		return fail(err)
+	}
+	if !changed {
+		if inv.jsonOutput {
+			if err := inv.writeResult(operationResult{Operation: strings.ToLower(operation), DryRun: cfg.dryRun, TotalKeys: len(allData), Parts: []partSummary{}}); err != nil {
+				return fail(err)
+			}
+		}
+		return 0
+	}
+
+	if cfg.maxKeys > 0 && len(allData) > cfg.maxKeys {
+		return fail(multipart.WithCode(multipart.CodeTooManyKeys, "", fmt.Errorf("merged data has %d keys, which exceeds --max-keys (%d). Refusing to write; check the input or raise --max-keys", len(allData), cfg.maxKeys)))
+	}
+
+	if cfg.schema != nil {
+		if err := validateSchema(cfg.schema, allData); err != nil {
+			return fail(err)
+		}
+	}
+
+	encryptPaths := cfg.encryptPaths
+	for _, path := range storedEncrypted {
+		value, exists := valueAtSegments(allData, multipart.SplitJSONPath(path))
+		if !exists || multipart.IsEncrypted(value) {
+			continue
+		}
+		if cfg.encryptionKey == nil {
+			return fail(multipart.WithCode(multipart.CodeEncryption, path, fmt.Errorf("key '%s' is stored encrypted; pass --encryption-key-file to encrypt its new value", path)))
+		}
+		encryptPaths = append(encryptPaths, path)
+	}
+	if len(encryptPaths) > 0 {
+		encrypted, err := multipart.EncryptPaths(allData, encryptPaths, cfg.encryptionKey)
+		if err != nil {
+			return fail(err)
+		}
+		fmt.Fprintf(infoOut, "Encrypted %d value(s)\n", encrypted)
+	}
+
+	chunkOpts := cfg.chunkOpts
+	if err := pinFlags(chunkOpts.Pins).checkNumbering(naming, base, numbers); err != nil {
+		return fail(err)
+	}
+	if cfg.preserveOrder {
+		// Stored keys keep their place and new keys follow in input order
+		order := multipart.NewInsertionOrder()
+		if !e.replace {
+			for _, part := range existingParts {
+				if err := order.Record(part.Raw, ""); err != nil {
+					return fail(err)
+				}
+			}
+		}
+		if cfg.input != "" {
+			if err := order.Record(cfg.input, cfg.inputPath); err != nil {
+				return fail(err)
+			}
+		}
+		chunkOpts.Order, sm.Order, sm.KeyOrder = order, order, chunkOpts.KeyOrder
+	}
+	chunkStart := time.Now()
+	var chunks []map[string]interface{}
+	// A change confined to the keys of one part is written to that part alone. Settings that
+	// apply to every part written (tags, descriptions, replicas, stages, pins) need a full rewrite
+	single := -1
+	localized := false
+	if !cfg.fullRedistribute && !cfg.noMultipart && !e.repack && len(chunkOpts.Pins) == 0 && !sm.SyncTags && !sm.SyncDescription && len(sm.ReplicaRegions) == 0 && sm.MoveStage == "" && len(numbers) > 1 {
+		names, err := naming.PlanPartNames(base, numbers, len(numbers), cfg.maxParts)
+		if err != nil {
+			return fail(err)
+		}
+		var layout []map[string]interface{}
+		layout, single, localized, err = localizedLayout(names, existingParts, allData, chunkOpts)
+		if err != nil {
+			return fail(err)
+		}
+		chunks = layout
+	}
+	switch {
+	case localized:
+	case cfg.noMultipart:
+		js, err := chunkOpts.Marshal(allData)
+		if err != nil {
+			return fail(fmt.Errorf("failed to marshal secret data: %w", err))
+		}
+		if size := multipart.SecretSize(string(js)); size > chunkOpts.MaxSize {
+			return fail(multipart.WithCode(multipart.CodeSizeExceeded, "", fmt.Errorf("secret data is %d bytes, which exceeds --max-secret-size (%d bytes), and --no-multipart does not split it into parts", size, chunkOpts.MaxSize)))
+		}
+		chunks = []map[string]interface{}{allData}
+	default:
+		chunks, err = multipart.ChunkDataIntoSecrets(allData, chunkOpts)
+		if err != nil {
+			return fail(err)
+		}
+	}
+	if err := multipart.CheckChunks(allData, chunks); err != nil {
+		return fail(err)
+	}
+	inv.timer.track("chunk", chunkStart)
+	if cfg.dominantThreshold > 0 && !cfg.noMultipart {
+		if err := warnDominantKeys(inv.stderr, allData, chunkOpts, cfg.dominantThreshold); err != nil {
+			return fail(err)
+		}
+	}
+	// Deleting can shrink the data enough to need fewer parts. Never write empty
+	// "{}" parts; stop before any write so the set stays consistent.
+	if e.shrinking != "" && len(chunks) < len(numbers) && !cfg.prune {
+		return fail(emptyPartsError(e.shrinkingKey, e.shrinking, len(chunks), len(numbers)))
+	}
+	writeNumbers, pruneNumbers := numbers, []int(nil)
+	if cfg.prune {
+		if len(chunks) == 0 {
+			return fail(multipart.WithCode(multipart.CodeEmptyParts, "", fmt.Errorf("no keys left to write and the base secret '%s' is never pruned", base)))
+		}
+		writeNumbers, pruneNumbers = multipart.SplitPrunableParts(numbers, len(chunks))
+	}
+	parts, err := summarizeParts(naming, base, chunks, writeNumbers, cfg.maxParts, chunkOpts)
+	if err != nil {
+		return fail(err)
+	}
+	pruned := make([]string, 0, len(pruneNumbers))
+	for _, n := range pruneNumbers {
+		pruned = append(pruned, naming.PartName(base, n))
+	}
+	if e.repack && len(pruned) == 0 {
+		unchanged, err := partsUnchanged(parts, chunks, existingParts, chunkOpts)
+		if err != nil {
+			return fail(err)
+		}
+		if unchanged {
+			fmt.Fprintf(infoOut, "All %d part(s) are already packed this way, nothing to write\n", len(parts))
+			if inv.jsonOutput {
+				if err := inv.writeResult(operationResult{Operation: "normalize", DryRun: cfg.dryRun, TotalKeys: len(allData), Parts: []partSummary{}}); err != nil {
+					return fail(err)
+				}
+			}
+			return 0
+		}
+	}
+	if localized && single < 0 {
+		fmt.Fprintf(infoOut, "No part's content changes, nothing to write\n")
+		if inv.jsonOutput {
+			if err := inv.writeResult(operationResult{Operation: strings.ToLower(operation), DryRun: cfg.dryRun, TotalKeys: len(allData), Deleted: deleted, Parts: []partSummary{}}); err != nil {
+				return fail(err)
+			}
+		}
+		return 0
+	}
+	totals := fmt.Sprintf("Total keys: %d, Total secrets: %d", len(allData), len(chunks))
+	if len(deleted) > 0 {
+		totals = fmt.Sprintf("Deleted keys: %d, %s", len(deleted), totals)
+	}
+	// untouched holds the VersionId of every part a single-part write was laid out around
+	var untouched map[string]string
+	if localized {
+		if !cfg.noConcurrencyCheck {
+			untouched = make(map[string]string, len(existingParts))
+			for _, part := range existingParts {
+				if part.Name != parts[single].Name {
+					untouched[part.Name] = part.VersionID
+				}
+			}
+		}
+		number, _ := naming.ParsePartNumber(base, parts[single].Name)
+		fmt.Fprintf(infoOut, "Only '%s' changes; writing it alone (use --full-redistribute to rewrite every part)\n", parts[single].Name)
+		parts, chunks, writeNumbers = parts[single:single+1], chunks[single:single+1], []int{number}
+	}
+	result := operationResult{Operation: strings.ToLower(operation), DryRun: cfg.dryRun, TotalKeys: len(allData), Deleted: deleted, Parts: parts, Pruned: pruned}
+	if cfg.dryRun {
+		if cfg.verbose {
+			result.Plan = planCalls(sm, parts, pruned, untouched, cfg.lockName, cfg.identity)
+		}
+		result.KeyMoves = keyMovements(naming, base, existingParts, parts, chunks, pruned)
+		if inv.jsonOutput {
+			result.Timings, inv.timer.reported = inv.timer.report(), inv.timer.enabled
+			return inv.exit(inv.writeResult(result))
+		}
+		printDryRun(inv.stdout, parts, pruned, result.Plan)
+		printKeyMovements(inv.stdout, result.KeyMoves)
+		fmt.Fprintf(inv.stdout, "%s dry run completed. %s\n", operation, totals)
+		return 0
+	}
+	if err := cfg.prompt.confirm(fmt.Sprintf("About to write %d part(s) and delete %d part(s) of '%s'.", len(parts), len(pruned), base)); err != nil {
+		return fail(err)
+	}
+	if cfg.backupDir != "" {
+		dir, err := backupParts(cfg.backupDir, base, existingParts, time.Now())
+		if err != nil {
+			return fail(fmt.Errorf("failed to back up existing parts: %w", err))
+		}
+		fmt.Fprintf(infoOut, "Backed up %d part(s) to %s\n", len(existingParts), dir)
+	}
+	var versions map[string]string
+	if !cfg.noConcurrencyCheck {
+		versions = make(map[string]string, len(existingParts))
+		for _, part := range existingParts {
+			versions[part.Name] = part.VersionID
+		}
+	}
+	var previous map[string]string
+	if !cfg.noRollback {
+		previous = make(map[string]string, len(existingParts))
+		for _, part := range existingParts {
+			previous[part.Name] = part.Raw
+		}
+	}
+	writeStart := time.Now()
+	if err := sm.CheckVersions(ctx, untouched); err != nil {
+		return fail(fmt.Errorf("failed to redistribute secrets: %w", err))
+	}
+	err = sm.RedistributeSecrets(ctx, base, chunks, cfg.tags, writeNumbers, versions, previous)
+	inv.timer.track("write", writeStart)
+	if err != nil {
+		return fail(fmt.Errorf("failed to redistribute secrets: %w", err))
+	}
+	if len(pruneNumbers) > 0 {
+		pruneStart := time.Now()
+		err = sm.DeleteParts(ctx, base, pruneNumbers)
+		inv.timer.track("prune", pruneStart)
+		if err != nil {
+			return fail(fmt.Errorf("failed to prune unused parts: %w", err))
+		}
+	}
+	for _, name := range pruned {
+		if sm.ForceDelete {
+			fmt.Fprintf(infoOut, "Permanently deleted unused part '%s'\n", name)
+			continue
+		}
+		fmt.Fprintf(infoOut, "Scheduled deletion of unused part '%s' (recoverable during the recovery window)\n", name)
+	}
+	if inv.jsonOutput {
+		result.Timings, inv.timer.reported = inv.timer.report(), inv.timer.enabled
+		return inv.exit(inv.writeResult(result))
+	}
+	fmt.Fprintf(inv.summaryOut, "%s operation completed successfully. %s\n", operation, totals)
+	for _, part := range parts {
+		fmt.Fprintf(inv.summaryOut, "  %s: %s, %d bytes remaining\n", part.Name, part.sizeUsage(), part.Limit-part.Bytes)
+	}
+	return 0
+}
+
+// newSecretsManagerClient builds the Secrets Manager client used by run
+// It is a variable so tests can substitute a mock client
+var newSecretsManagerClient = func(ctx context.Context, optFns ...func(*config.LoadOptions) error) (multipart.SecretsManagerClient, error) {
+	cfg, err := config.LoadDefaultConfig(ctx, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	return secretsmanager.NewFromConfig(cfg), nil
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run parses args, executes the requested operation and returns the process exit code
+// stdin supplies '-' inputs and confirmation answers; regular output goes to stdout, errors
+// and prompts to stderr
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) (code int) {
+	flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	infoOut = stdout
+
+	env := flags.String("env", "", "The environment (e.g., staging, prod)")
+	secretName := flags.String("secret_name", "", "Base name of the secret")
+	jsonData := flags.String("json_data", "", "JSON data containing key-value pairs to add ('-' reads the JSON from stdin)")
+	jsonFile := flags.String("json_file", "", "Path to a file containing JSON key-value pairs to add (alternative to --json_data)")
+	schemaFile := flags.String("schema", "", "Path to a JSON Schema file the merged secret data must satisfy before anything is written")
+	validateNested := flags.Bool("validate-nested", false, "Reject string values that look like escaped JSON (start with '{' or '[') but do not parse")
+	strictKeys := flags.Bool("strict-keys", false, "Reject input keys containing '.', which cannot be addressed with dot-notation --json_path")
+	jsonPath := flags.String("json_path", "", "Dot notation path for operations (e.g., 'Cred.Db.Username' for find, 'Cred.Db' for add nested). For add/update: path to nested object. For find: full path to key, where '*' and '?' match within one segment (e.g. 'Db.*.Password') and every match is listed. For find and delete: several comma-separated paths are handled in one run (escape a comma in a key as '\\,'). Escape a literal dot in a key as '\\.' (e.g., 'service\\.domain\\.com.Token').")
+	pathsFile := flags.String("paths-file", "", "File listing the --find-key or --delete-key paths, one per line (blank lines and lines starting with '#' are ignored); used instead of --json_path")
+	jsonPathQuery := flags.String("jsonpath", "", "With --find-key or --get-value, select keys with an RFC 9535 JSONPath expression evaluated against the merged data instead of --json_path (e.g. '$.Servers[?@.port > 8000].host'); every match is listed")
+	allOccurrences := flags.Bool("all-occurrences", false, "With --find-key, list every key whose path ends with --json_path, at any depth and in every part (e.g. 'Password' finds Db.Cred.Password and Cache.Cred.Password), instead of only the exact path")
+	forceUpdate := flags.Bool("force_update", false, "Enable update mode. If true, updates existing keys (fails if missing). If false, adds new keys (fails if exists).")
+	dottedKeys := flags.Bool("dotted-keys", false, "Treat input keys containing '.' as paths: 'Db.Cred.User' adds 'User' to the existing object Db.Cred (relative to --json_path), so one input can add root-level and nested keys; escape a literal dot as '\\.'")
+	stringifyValues := flags.Bool("stringify-values", false, "Store every top-level input value as a string, the legacy shape: numbers and booleans become quoted strings and objects and arrays escaped JSON strings")
+	merge := flags.Bool("merge", false, "Deep-merge objects into existing objects instead of failing on existing keys; only scalar leaves are overwritten")
+	preserveTypes := flags.Bool("preserve-types", false, "When overwriting existing keys (--force_update, --merge or --set-key), fail if the new value's JSON type differs from the stored one")
+	encryptKeys := flags.String("encrypt-keys", "", "Comma-separated dot-notation paths whose values are encrypted with AES-GCM before storage (requires --encryption-key-file)")
+	encryptionKeyFile := flags.String("encryption-key-file", "", "File holding the base64 encoded 16, 24 or 32 byte AES key for --encrypt-keys; writes also re-encrypt the stored encrypted values they overwrite, and with --get-value, --export or --export-env encrypted values are decrypted")
+	skipExisting := flags.Bool("skip-existing", false, "Skip keys whose stored value already equals the given value instead of failing, so re-running the same add is a no-op")
+	region := flags.String("region", "", "AWS region to use (e.g., us-east-1). Overrides AWS_REGION when set")
+	endpointURL := flags.String("endpoint-url", "", "Send Secrets Manager requests to this endpoint instead of AWS, e.g. http://localhost:4566 for LocalStack")
+	backend := flags.String("backend", "aws", "Secret store: 'aws' (Secrets Manager) or 'file' (one <part name>.json file per part under --dir, for tests and offline use)")
+	backendDir := flags.String("dir", "", "With --backend file, the directory holding the secret files")
+	profile := flags.String("profile", "", "AWS named profile from the shared config/credentials files. Overrides AWS_PROFILE when set")
+	timeout := flags.Duration("timeout", 30*time.Second, "Overall deadline for all AWS operations (including time spent at the confirmation prompt)")
+	maxRetries := flags.Int("max-retries", 3, "Maximum number of retries for throttled, timed out or 5xx AWS calls (0 disables retries)")
+	callRate := flags.Float64("rate", 0, "Maximum AWS API calls per second made by this run, to stay within account-wide quotas when many runs share them (0 means unlimited)")
+	retryMaxBackoff := flags.Duration("retry-max-backoff", 20*time.Second, "Upper bound for the exponential backoff delay between retries")
+	extraTags := tagFlags{}
+	flags.Var(extraTags, "tag", "Tag to apply to created parts as key=value (repeatable, overrides the default temp:env/temp:feature tags)")
+	pins := pinFlags{}
+	flags.Var(pins, "pin", "Place a top-level key in a given part as key=partNumber (0 = base, repeatable); the other keys are packed around the pinned ones. Needs part numbers without gaps")
+	binaryKeys := binaryKeyFlags{}
+	flags.Var(binaryKeys, "binary-key", "Store the content of a file base64 encoded at a dot-notation path, as path=file (repeatable); --get-value prints the decoded bytes")
+	var replicaRegions regionFlags
+	flags.Var(&replicaRegions, "replica-region", "Region to replicate every written part to (repeatable); created parts are replicated on creation, existing ones that lack the region are replicated on update")
+	var partNumbers partFlags
+	flags.Var(&partNumbers, "parts", "Read only these part numbers, comma-separated (0 = base), e.g. 0,1; skips listing the set. Read-only lookups only")
+	errorsFormat := flags.String("errors", "text", "Error format on stderr: 'text' (ERROR: message) or 'json' (one object per error with a stable code, e.g. KEY_EXISTS)")
+	output := flags.String("output", "text", "Output format: 'text' (human readable) or 'json' (single JSON object on stdout)")
+	outputFile := flags.String("output-file", "", "With --output json, write the result object to this file instead of stdout")
+	findKeyMode := flags.Bool("find-key", false, "Find mode: Search for key specified in --json_path across multipart secrets")
+	deletePrefix := flags.String("delete-prefix", "", "Delete-prefix mode: Remove every key under this dot-notation path (e.g. 'LegacyService') and repack the remaining keys (requires --yes)")
+	deleteKeyMode := flags.Bool("delete-key", false, "Delete mode: Remove key specified in --json_path from multipart secrets and repack the remaining keys")
+	setKey := flags.String("set-key", "", "Set mode: Replace the value of the existing key at this dot-notation path with --value or --value-file")
+	appendTo := flags.String("append-to", "", "Append mode: Append --value or --value-file as a string element to the existing array at this dot-notation path")
+	setValue := flags.String("value", "", "With --set-key or --append-to, the new string value")
+	setValueFile := flags.String("value-file", "", "With --set-key or --append-to, read the new string value from this file (used verbatim, including any trailing newline)")
+	findValueStr := flags.String("find-value", "", "Find-value mode: Print the path and part of every key whose value equals this string (values are never printed)")
+	containsMatch := flags.Bool("contains", false, "With --find-value, match values containing the string instead of equal to it")
+	listKeysMode := flags.Bool("list-keys", false, "List mode: Print all keys (without values) and the multipart secret each lives in")
+	keyPrefix := flags.String("prefix", "", "With --list-keys or --find-value, only show keys under this dot-notation path (e.g. 'Db.Cred'); with --find-key, search --json_path relative to it")
+	recursive := flags.Bool("recursive", false, "With --list-keys, recurse into nested objects and print dot-notation paths; with --export-env, export one variable per nested leaf")
+	exportEnvMode := flags.Bool("export-env", false, "Export-env mode: Print the merged data as shell-quoted export NAME='value' lines for eval (nested objects are JSON-encoded unless --recursive, binary values are decoded)")
+	getValueMode := flags.Bool("get-value", false, "Get mode: Print only the raw value at --json_path to stdout (JSON for nested objects)")
+	maxSecretSize := flags.Int("max-secret-size", multipart.MaxSecretSizeBytes, fmt.Sprintf("Maximum size in bytes of each multipart secret (must not exceed AWS limit of %d)", multipart.AWSMaxSecretSizeBytes))
+	maxParts := flags.Int("max-parts", multipart.DefaultMaxParts, fmt.Sprintf("Highest multipart suffix number to read or create (base-1 .. base-N). Up to %d secrets are fetched per BatchGetSecretValue call, larger sets are fetched in concurrent batches", multipart.MaxBatchSecretIDs))
+	maxKeys := flags.Int("max-keys", 10000, "Refuse to write when the merged data has more than this many top-level keys, guarding against runaway input (0 disables the limit)")
+	noMultipart := flags.Bool("no-multipart", false, "Treat the base as a single secret: never read or create parts, and fail instead of splitting when the data exceeds --max-secret-size")
+	partSeparator := flags.String("part-separator", "-", "Separator between the base name and the part number (base-1)")
+	partPadding := flags.Int("part-padding", 0, "Zero-pad part numbers to this many digits, e.g. 2 for base-01 (0 disables padding)")
+	packStrategy := flags.String("pack-strategy", multipart.PackStrategyAlpha, "How keys are packed into parts: 'alpha' (alphabetical order) or 'compact' (first-fit-decreasing by size, fewer parts)")
+	keyOrder := flags.String("sort", multipart.SortCaseSensitive, "Key ordering used for chunking: 'case-sensitive' or 'case-insensitive'")
+	preserveOrder := flags.Bool("preserve-order", false, "Chunk and write keys in the order they were stored or given in the input instead of alphabetically; keys without a recorded position follow in --sort order")
+	dominantThreshold := flags.Float64("dominant-key-threshold", 0.5, "Warn when a single key takes more than this fraction of --max-secret-size (0 disables the warning)")
+	reportDuplicates := flags.Bool("report-duplicates", false, "Report keys duplicated across parts instead of failing; alone it only prints the report, with an add/delete it resolves them using --duplicate-policy")
+	duplicatePolicy := flags.String("duplicate-policy", multipart.DuplicateFirstWins, "With --report-duplicates, which part's value wins for a duplicated key: 'first' or 'last'")
+	fullRedistribute := flags.Bool("full-redistribute", false, "Repack and rewrite every part even when the change only affects keys of a single part, which by default is written alone")
+	pruneEmptyParts := flags.Bool("prune-empty-parts", false, "Schedule deletion of higher-numbered parts that are no longer needed after redistribution (the base secret is never deleted)")
+	auditLogFile := flags.String("audit-log", "", "Append a JSON line (timestamp, base, part, operation, key count, bytes, caller identity from STS) to this file for every part created or updated")
+	backupDir := flags.String("backup-dir", "", "Directory to snapshot every existing part's raw value into (timestamped, with manifest) before redistribution")
+	exportFile := flags.String("export", "", "Export mode: Write the merged data of all parts as indented JSON to this file ('-' for stdout), ordered by --sort; binary values stay base64 encoded so the file can be imported again")
+	verifyMode := flags.Bool("verify", false, "Verify mode: Audit the multipart set (contiguous numbering, valid JSON, size limit, no duplicate keys) and exit non-zero on problems")
+	normalizeMode := flags.Bool("normalize", false, "Normalize mode: Repack every key (with the compact strategy unless --pack-strategy is given) and rewrite the parts without changing any value; with --prune-empty-parts the part count can shrink")
+	describeMode := flags.Bool("describe", false, "Describe mode: Print each part's ARN, last changed date, KMS key, rotation status and tags (read-only)")
+	countMode := flags.Bool("count", false, "Count mode: Print the total number of keys and parts (read-only)")
+	lockMode := flags.Bool("lock", false, "Hold a lock on the base (the secret <base>-lock) while reading and writing the parts, so concurrent runs on other machines wait instead of overwriting each other")
+	lockTTL := flags.Duration("lock-ttl", 5*time.Minute, "With --lock, how long the lock stays valid; a lock left by a crashed run is taken over once it expired. Must be at least --timeout")
+	watchMode := flags.Bool("watch", false, "Watch mode: Poll the parts every --interval until interrupted and print a line whenever keys are added, removed or moved or a part's content changes (read-only, never prints values)")
+	watchInterval := flags.Duration("interval", 30*time.Second, "With --watch, how long to wait between polls; --timeout applies to each poll")
+	quiet := flags.Bool("quiet", false, "Suppress informational output such as overwrite notices, find results and the final summary; errors are still printed and a find without a match exits with code 3")
+	timings := flags.Bool("timings", false, "Print how long listing, fetching, chunking and writing the parts took, plus the total, to stderr (or into the --output json result)")
+	verbose := flags.Bool("verbose", false, "Print additional detail, e.g. the keys-per-part breakdown with --count or the planned API calls with --dry-run")
+	mergePatchFile := flags.String("merge-patch", "", "Merge-patch mode: Apply the RFC 7386 JSON Merge Patch in this file ('-' reads stdin) to the secret data: null removes a key, objects merge recursively and other values replace the stored ones")
+	importFile := flags.String("import", "", "Import mode: Replace all parts with the JSON object in this file, ignoring existing keys (requires --yes)")
+	copyTo := flags.String("copy-to", "", "Copy mode: Write the merged data of --secret_name to the parts of this base name, e.g. to clone an environment (created parts get the --tag tags; an existing non-empty target requires --force_update)")
+	restoreDir := flags.String("restore-dir", "", "Restore mode: Upload the parts of a backup directory created by --backup-dir")
+	onlyIfExists := flags.Bool("only-if-exists", false, "Exit successfully without doing anything when the base secret does not exist, instead of failing")
+	initBase := flags.Bool("init", false, "Create the base secret (empty, with the configured tags and KMS key) if it does not exist yet, then continue normally")
+	kmsKeyID := flags.String("kms-key-id", "", "KMS key ID or ARN used to encrypt newly created parts (defaults to the AWS managed key)")
+	compact := flags.Bool("compact", false, "Store parts as compact JSON without indentation so more keys fit per part")
+	forceDelete := flags.Bool("force-delete", false, "Delete pruned parts immediately without a recovery window (irreversible; requires --yes and --prune-empty-parts with --delete-key or --restore-dir)")
+	description := flags.String("description", "Part {part} of multipart secret {base}", "Description of newly created parts; {base} and {part} are replaced by the base name and part number (empty leaves it unset)")
+	syncDescription := flags.Bool("sync-description", false, "Also set --description on existing parts when they are updated")
+	syncTags := flags.Bool("sync-tags", false, "Reconcile tags on existing parts too (by default tags are only applied to newly created parts)")
+	writeConcurrency := flags.Int("write-concurrency", 1, "Maximum number of parts written in parallel")
+	missingParts := flags.String("missing-parts", "strict", "How read-only modes treat a part that disappeared after listing: 'strict' fails, 'lenient' skips it and continues with the remaining parts. Writes are always strict")
+	versionStage := flags.String("version-stage", multipart.StageCurrent, "Staging label of the parts to read (labels other than AWSCURRENT are only allowed in read-only modes)")
+	moveStage := flags.String("move-stage", "", "Staging label to move onto the new version of every part written, e.g. to keep a custom label in step with updates")
+	noRollback := flags.Bool("no-rollback", false, "Leave already written parts as they are when a later part fails to write, instead of restoring their previous values")
+	noConcurrencyCheck := flags.Bool("no-concurrency-check", false, "Skip verifying that parts were not modified by someone else between read and write")
+	assumeYes := flags.Bool("yes", false, "Skip the confirmation prompt before writing parts (required when stdin is not a terminal)")
+	dryRun := flags.Bool("dry-run", false, "Preview the resulting part layout without writing anything to AWS")
+	flags.Usage = func() { printUsage(flags, stderr) }
+	explicit, err := parseArgs(flags, args, stderr)
+	errorsJSON := *errorsFormat == "json"
+	if err != nil {
+		var coded *multipart.CodedError
+		switch {
+		case errors.Is(err, flag.ErrHelp):
+			return 0
+		case errors.As(err, &coded):
+			reportError(stderr, err, errorsJSON)
+		}
+		return exitUsage
+	}
+	prompt := prompter{in: stdin, out: stderr, assumeYes: *assumeYes}
+	// fail reports err and returns the exit code of its category
+	fail := func(err error) int {
+		reportError(stderr, err, errorsJSON)
+		return exitCode(err)
+	}
+
+	// Validate required flags
+	restoreMode := *restoreDir != ""
+	copyMode := *copyTo != ""
+	exportMode := *exportFile != ""
+	importMode := *importFile != ""
+	mergePatchMode := *mergePatchFile != ""
+	setMode := *setKey != ""
+	appendMode := *appendTo != ""
+	deletePrefixMode := *deletePrefix != ""
+	findValueMode := *findValueStr != ""
+	modes := []struct {
+		flag    string
+		enabled bool
+	}{
+		{"--find-key", *findKeyMode},
+		{"--find-value", findValueMode},
+		{"--delete-key", *deleteKeyMode},
+		{"--delete-prefix", deletePrefixMode},
+		{"--set-key", setMode},
+		{"--append-to", appendMode},
+		{"--list-keys", *listKeysMode},
+		{"--get-value", *getValueMode},
+		{"--restore-dir", restoreMode},
+		{"--copy-to", copyMode},
+		{"--export", exportMode},
+		{"--export-env", *exportEnvMode},
+		{"--import", importMode},
+		{"--merge-patch", mergePatchMode},
+		{"--normalize", *normalizeMode},
+		{"--count", *countMode},
+		{"--describe", *describeMode},
+		{"--verify", *verifyMode},
+		{"--watch", *watchMode},
+	}
+	modeCount := 0
+	modeFlags := make([]string, 0, len(modes))
+	for _, mode := range modes {
+		modeFlags = append(modeFlags, mode.flag)
+		if mode.enabled {
+			modeCount++
+		}
+	}
+	modeList := strings.Join(modeFlags, ", ")
+	pathMode := *findKeyMode || *deleteKeyMode || *getValueMode
+	jsonInput := *jsonData != "" || *jsonFile != ""
+	hasInput := jsonInput || len(binaryKeys) > 0
+	partScoped := *findKeyMode || findValueMode || *listKeysMode || *getValueMode || exportMode || *exportEnvMode || *countMode || *describeMode
+	readOnly := *findKeyMode || findValueMode || *listKeysMode || *getValueMode || exportMode || *exportEnvMode || *countMode || *describeMode || *verifyMode || *watchMode || (*reportDuplicates && !hasInput && modeCount == 0)
+	var usageErr string
+	switch {
+	case *env == "" || *secretName == "":
+		usageErr = "--env and --secret_name are required"
+	case modeCount > 1:
+		usageErr = fmt.Sprintf("Only one of %s can be used at a time", modeList)
+	case !hasInput && modeCount == 0 && !*reportDuplicates:
+		usageErr = fmt.Sprintf("Either --json_data/--json_file/--binary-key (for add/update) or one of %s is required", modeList)
+	case *jsonData != "" && *jsonFile != "":
+		usageErr = "Cannot use both --json_data and --json_file together"
+	case hasInput && modeCount > 0:
+		usageErr = fmt.Sprintf("Cannot use --json_data/--json_file/--binary-key together with %s", modeList)
+	case *pathsFile != "" && !*findKeyMode && !*deleteKeyMode:
+		usageErr = "--paths-file can only be used with --find-key or --delete-key"
+	case *pathsFile != "" && *jsonPath != "":
+		usageErr = "Cannot use both --json_path and --paths-file together"
+	case *jsonPathQuery != "" && !*findKeyMode && !*getValueMode:
+		usageErr = "--jsonpath can only be used with --find-key or --get-value"
+	case *jsonPathQuery != "" && (*jsonPath != "" || *pathsFile != ""):
+		usageErr = "Cannot use --jsonpath together with --json_path or --paths-file"
+	case *jsonPathQuery != "" && (*keyPrefix != "" || *allOccurrences):
+		usageErr = "--jsonpath cannot be used with --prefix or --all-occurrences; express them in the JSONPath query"
+	case pathMode && *jsonPath == "" && *pathsFile == "" && *jsonPathQuery == "":
+		usageErr = "--json_path is required in find-key, delete-key and get-value modes (e.g., 'username' or 'Db.Cred.Username')"
+	case setMode && (*setValue == "") == (*setValueFile == ""):
+		usageErr = "--set-key requires exactly one of --value or --value-file"
+	case appendMode && (*setValue == "") == (*setValueFile == ""):
+		usageErr = "--append-to requires exactly one of --value or --value-file"
+	case !setMode && !appendMode && (*setValue != "" || *setValueFile != ""):
+		usageErr = "--value and --value-file can only be used with --set-key or --append-to"
+	case importMode && !*assumeYes:
+		usageErr = "--import replaces every part of the secret and requires --yes"
+	case deletePrefixMode && !*assumeYes && !*dryRun:
+		usageErr = "--delete-prefix removes every key under the prefix and requires --yes (or --dry-run to preview)"
+	case *forceDelete && !(*pruneEmptyParts && (*deleteKeyMode || deletePrefixMode || *restoreDir != "" || copyMode || *normalizeMode)):
+		usageErr = "--force-delete can only be used with --prune-empty-parts in --delete-key, --delete-prefix, --restore-dir, --copy-to or --normalize mode"
+	case *forceDelete && !*assumeYes:
+		usageErr = "--force-delete deletes parts irreversibly and requires --yes"
+	case *onlyIfExists && *initBase:
+		usageErr = "--only-if-exists and --init cannot be used together"
+	case *dottedKeys && !jsonInput:
+		usageErr = "--dotted-keys can only be used when adding keys with --json_data/--json_file"
+	case *dottedKeys && *strictKeys:
+		usageErr = "--dotted-keys routes keys containing '.' to nested paths, which --strict-keys rejects; use only one of them"
+	case *stringifyValues && !jsonInput:
+		usageErr = "--stringify-values can only be used when adding keys with --json_data/--json_file"
+	case *stringifyValues && *merge:
+		usageErr = "--stringify-values stores objects as strings, which cannot be deep-merged with --merge"
+	case *merge && !hasInput:
+		usageErr = "--merge can only be used when adding keys with --json_data/--json_file"
+	case *merge && *forceUpdate:
+		usageErr = "--merge already overwrites existing leaves and cannot be combined with --force_update"
+	case *schemaFile != "" && !hasInput && !importMode && !setMode && !appendMode && !*deleteKeyMode && !deletePrefixMode && !mergePatchMode:
+		usageErr = "--schema can only be used when writing keys (add, --set-key, --append-to, --delete-key, --delete-prefix, --merge-patch or --import)"
+	case *preserveTypes && !*forceUpdate && !*merge && !setMode:
+		usageErr = "--preserve-types only applies when overwriting keys with --force_update, --merge or --set-key"
+	case *encryptKeys != "" && *encryptionKeyFile == "":
+		usageErr = "--encrypt-keys requires --encryption-key-file"
+	case *encryptKeys != "" && !hasInput && !setMode && !appendMode && !importMode && !mergePatchMode:
+		usageErr = "--encrypt-keys can only be used when writing keys (add, --set-key, --append-to, --merge-patch or --import)"
+	case *encryptionKeyFile != "" && *encryptKeys == "" && !hasInput && !setMode && !appendMode && !mergePatchMode && !*getValueMode && !exportMode && !*exportEnvMode:
+		usageErr = "--encryption-key-file can only be used when writing keys or with --get-value, --export or --export-env"
+	case *skipExisting && !hasInput:
+		usageErr = "--skip-existing can only be used when adding keys with --json_data/--json_file"
+	case *allOccurrences && !*findKeyMode:
+		usageErr = "--all-occurrences can only be used with --find-key"
+	case *containsMatch && !findValueMode:
+		usageErr = "--contains can only be used with --find-value"
+	case mergePatchMode && *jsonPath != "":
+		usageErr = "--json_path cannot be used with --merge-patch; nest the patch document instead"
+	case *missingParts != "strict" && *missingParts != "lenient":
+		usageErr = fmt.Sprintf("--missing-parts must be 'strict' or 'lenient', got '%s'", *missingParts)
+	case *missingParts == "lenient" && !(*findKeyMode || *getValueMode || *listKeysMode || *countMode || exportMode || *exportEnvMode || findValueMode):
+		usageErr = "--missing-parts lenient can only be used with read-only modes (--find-key, --find-value, --get-value, --list-keys, --count, --export or --export-env); writes must see every part"
+	case *versionStage == "":
+		usageErr = "--version-stage must not be empty"
+	case *versionStage != multipart.StageCurrent && !(*findKeyMode || *getValueMode || *listKeysMode || *countMode || exportMode || *exportEnvMode || findValueMode || *verifyMode):
+		usageErr = fmt.Sprintf("--version-stage %s can only be used with read-only modes; writes always start from %s", *versionStage, multipart.StageCurrent)
+	case *moveStage == multipart.StageCurrent || *moveStage == multipart.StagePrevious:
+		usageErr = fmt.Sprintf("--move-stage cannot move %s, which Secrets Manager manages on every update", *moveStage)
+	case *recursive && !*listKeysMode && !*exportEnvMode:
+		usageErr = "--recursive can only be used with --list-keys or --export-env"
+	case *keyPrefix != "" && !(*listKeysMode || *findKeyMode || findValueMode):
+		usageErr = "--prefix can only be used with --list-keys, --find-key or --find-value"
+	case *region != "" && !regionPattern.MatchString(*region):
+		usageErr = fmt.Sprintf("--region '%s' is not a valid AWS region (expected e.g. 'us-east-1')", *region)
+	case *partSeparator == "" || invalidNameChars.MatchString(*partSeparator):
+		usageErr = fmt.Sprintf("--part-separator must be non-empty and use only characters allowed in secret names (letters, digits and /_+=.@-), got '%s'", *partSeparator)
+	case *noMultipart && *pruneEmptyParts:
+		usageErr = "--prune-empty-parts cannot be used with --no-multipart, which never creates parts"
+	case *partPadding < 0 || *partPadding > 9:
+		usageErr = fmt.Sprintf("--part-padding must be between 0 and 9, got %d", *partPadding)
+	case *endpointURL != "" && !validEndpointURL(*endpointURL):
+		usageErr = fmt.Sprintf("--endpoint-url must be an absolute http or https URL, got '%s'", *endpointURL)
+	case len(replicaRegions) > 0 && *backend != "aws":
+		usageErr = "--replica-region can only be used with --backend aws"
+	case *region != "" && slices.Contains(replicaRegions, *region):
+		usageErr = fmt.Sprintf("--replica-region %s is the primary region of the secrets", *region)
+	case *endpointURL != "" && *backend != "aws":
+		usageErr = "--endpoint-url can only be used with --backend aws"
+	case *backend != "aws" && *backend != "file":
+		usageErr = fmt.Sprintf("--backend must be 'aws' or 'file', got '%s'", *backend)
+	case *backend == "file" && *backendDir == "":
+		usageErr = "--backend file requires --dir"
+	case *backend != "file" && *backendDir != "":
+		usageErr = "--dir can only be used with --backend file"
+	case *timeout <= 0:
+		usageErr = fmt.Sprintf("--timeout must be positive, got %s", *timeout)
+	case *maxRetries < 0:
+		usageErr = fmt.Sprintf("--max-retries must not be negative, got %d", *maxRetries)
+	case *maxKeys < 0:
+		usageErr = fmt.Sprintf("--max-keys must not be negative, got %d", *maxKeys)
+	case *writeConcurrency < 1:
+		usageErr = fmt.Sprintf("--write-concurrency must be at least 1, got %d", *writeConcurrency)
+	case len(pins) > 0 && *noMultipart:
+		usageErr = "--pin cannot be used with --no-multipart, which stores everything in the base secret"
+	case pins.maxPart() > *maxParts:
+		usageErr = fmt.Sprintf("--pin part %d exceeds --max-parts (%d)", pins.maxPart(), *maxParts)
+	case *preserveOrder && (copyMode || restoreMode):
+		usageErr = "--preserve-order cannot be used with --copy-to or --restore-dir"
+	case len(*description) > AWSMaxDescriptionLength:
+		usageErr = fmt.Sprintf("--description is %d characters, AWS allows at most %d", len(*description), AWSMaxDescriptionLength)
+	case *syncDescription && *description == "":
+		usageErr = "--sync-description requires a non-empty --description"
+	case len(partNumbers) > 0 && !partScoped:
+		usageErr = "--parts can only be used with --find-key, --find-value, --get-value, --list-keys, --count, --describe, --export or --export-env; writes and --verify need every part"
+	case len(partNumbers) > 0 && *noMultipart:
+		usageErr = "--parts cannot be used with --no-multipart, which reads only the base secret"
+	case partNumbers.maxPart() > *maxParts:
+		usageErr = fmt.Sprintf("--parts part %d exceeds --max-parts (%d)", partNumbers.maxPart(), *maxParts)
+	case *lockMode && readOnly:
+		usageErr = "--lock only applies to operations that write parts"
+	case *lockMode && *backend == "file":
+		usageErr = "--lock needs staging labels, which the file backend does not support"
+	case explicit["lock-ttl"] && !*lockMode:
+		usageErr = "--lock-ttl can only be used with --lock"
+	case *lockMode && *lockTTL < *timeout:
+		usageErr = fmt.Sprintf("--lock-ttl (%s) must be at least --timeout (%s) so the lock cannot expire during the run", *lockTTL, *timeout)
+	case *watchInterval <= 0:
+		usageErr = fmt.Sprintf("--interval must be positive, got %s", *watchInterval)
+	case explicit["interval"] && !*watchMode:
+		usageErr = "--interval can only be used with --watch"
+	case *watchMode && *output == "json":
+		usageErr = "--watch prints one line per change and cannot be used with --output json"
+	case *callRate < 0:
+		usageErr = fmt.Sprintf("--rate must not be negative, got %g", *callRate)
+	case *retryMaxBackoff <= 0:
+		usageErr = fmt.Sprintf("--retry-max-backoff must be positive, got %s", *retryMaxBackoff)
+	case *dominantThreshold < 0 || *dominantThreshold > 1:
+		usageErr = fmt.Sprintf("--dominant-key-threshold must be between 0 and 1, got %g", *dominantThreshold)
+	case *packStrategy != multipart.PackStrategyAlpha && *packStrategy != multipart.PackStrategyCompact:
+		usageErr = fmt.Sprintf("--pack-strategy must be '%s' or '%s', got '%s'", multipart.PackStrategyAlpha, multipart.PackStrategyCompact, *packStrategy)
+	case *keyOrder != multipart.SortCaseSensitive && *keyOrder != multipart.SortCaseInsensitive:
+		usageErr = fmt.Sprintf("--sort must be '%s' or '%s', got '%s'", multipart.SortCaseSensitive, multipart.SortCaseInsensitive, *keyOrder)
+	case *duplicatePolicy != multipart.DuplicateFirstWins && *duplicatePolicy != multipart.DuplicateLastWins:
+		usageErr = fmt.Sprintf("--duplicate-policy must be '%s' or '%s', got '%s'", multipart.DuplicateFirstWins, multipart.DuplicateLastWins, *duplicatePolicy)
+	case *output != "text" && *output != "json":
+		usageErr = fmt.Sprintf("--output must be 'text' or 'json', got '%s'", *output)
+	case *errorsFormat != "text" && *errorsFormat != "json":
+		usageErr = fmt.Sprintf("--errors must be 'text' or 'json', got '%s'", *errorsFormat)
+	case *outputFile != "" && *output != "json":
+		usageErr = "--output-file requires --output json"
+	}
+	if usageErr != "" {
+		return fail(multipart.WithCode(multipart.CodeUsage, "", errors.New(usageErr)))
+	}
+
+	jsonOutput := *output == "json"
+	if jsonOutput {
+		// Keep stdout reserved for the single JSON result object
+		infoOut = stderr
+	}
+	// summaryOut receives the human readable results that --quiet suppresses
+	summaryOut := stdout
+	if *quiet {
+		infoOut, summaryOut = io.Discard, io.Discard
+	}
+	timer := newPhaseTimer(*timings)
+	defer func() {
+		if !timer.reported {
+			timer.print(stderr)
+		}
+	}()
+	// writeResult emits the --output json result object
+	writeResult := func(v interface{}) error {
+		if *outputFile != "" {
+			return writeJSONResultFile(*outputFile, v)
+		}
+		return writeJSONResult(stdout, v)
+	}
+
+	if *maxSecretSize <= 0 || *maxSecretSize > multipart.AWSMaxSecretSizeBytes {
+		return fail(multipart.WithCode(multipart.CodeUsage, "", fmt.Errorf("--max-secret-size must be between 1 and %d bytes (AWS limit), got %d", multipart.AWSMaxSecretSizeBytes, *maxSecretSize)))
+	}
+
+	if *maxParts < 1 {
+		return fail(multipart.WithCode(multipart.CodeUsage, "", fmt.Errorf("--max-parts must be at least 1, got %d", *maxParts)))
+	}
+	naming := multipart.Naming{Separator: *partSeparator, Padding: *partPadding}
+
+	// Compile the schema up front so a broken schema fails before any AWS call
+	var schema *jsonschema.Schema
+	if *schemaFile != "" {
+		var err error
+		schema, err = loadSchema(*schemaFile)
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	// Find and delete accept several paths, handled with a single fetch and redistribution
+	var paths []string
+	if *pathsFile != "" {
+		var err error
+		paths, err = readPathsFile(*pathsFile)
+		if err != nil {
+			return fail(multipart.WithCode(multipart.CodeUsage, "", err))
+		}
+	} else if (*findKeyMode && *jsonPathQuery == "") || *deleteKeyMode {
+		if paths = splitPathList(*jsonPath); len(paths) == 0 {
+			return fail(multipart.WithCode(multipart.CodeUsage, "", errors.New("--json_path lists no paths")))
+		}
+	}
+
+	var jsonPathExpr *jsonpath.Path
+	if *jsonPathQuery != "" {
+		var err error
+		if jsonPathExpr, err = parseJSONPath(*jsonPathQuery); err != nil {
+			return fail(err)
+		}
+	}
+
+	var encryptionKey []byte
+	var encryptPaths []string
+	if *encryptionKeyFile != "" {
+		var err error
+		encryptionKey, err = readEncryptionKey(*encryptionKeyFile)
+		if err != nil {
+			return fail(multipart.WithCode(multipart.CodeUsage, "", err))
+		}
+		for _, path := range strings.Split(*encryptKeys, ",") {
+			if path = strings.TrimSpace(path); path != "" {
+				encryptPaths = append(encryptPaths, path)
+			}
+		}
+	}
+
+	// An interrupt cancels the root context; a second one falls back to the default handler
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-sigCtx.Done()
+		stop()
+	}()
+
+	// Every AWS call shares one deadline so a hung request cannot block forever
+	ctx, cancel := context.WithTimeout(sigCtx, *timeout)
+	defer cancel()
+	defer func() {
+		// With --errors json the cause is already classified as TIMEOUT or INTERRUPTED
+		if code == 0 || errorsJSON {
+			return
+		}
+		switch {
+		case sigCtx.Err() != nil:
+			fmt.Fprintf(stderr, "ERROR: interrupted\n")
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			fmt.Fprintf(stderr, "ERROR: operation timed out after %s\n", *timeout)
+		}
+	}()
+
+	// Without multipart there are no parts, so names ending in a part suffix are ordinary secrets
+	partLimit := *maxParts
+	if *noMultipart {
+		partLimit = 0
+	}
+	baseSecretName, err := verifySecretName(naming, *secretName, partLimit)
+	if err != nil {
+		return fail(err)
+	}
+	var copyTarget string
+	if copyMode {
+		copyTarget, err = verifySecretName(naming, *copyTo, partLimit)
+		if err != nil {
+			return fail(err)
+		}
+		if copyTarget == baseSecretName {
+			return fail(multipart.WithCode(multipart.CodeUsage, "", fmt.Errorf("--copy-to must name a different base than --secret_name ('%s')", baseSecretName)))
+		}
+	}
+	// The standard retryer only retries throttling, timeout and 5xx errors; validation errors fail fast
+	cfgOpts := []func(*config.LoadOptions) error{
+		config.WithRetryer(func() aws.Retryer {
+			return retry.NewStandard(func(o *retry.StandardOptions) {
+				o.MaxAttempts = *maxRetries + 1
+				o.Backoff = retry.NewExponentialJitterBackoff(*retryMaxBackoff)
+			})
+		}),
+	}
+	if *region != "" {
+		cfgOpts = append(cfgOpts, config.WithRegion(*region))
+	}
+	if *profile != "" {
+		cfgOpts = append(cfgOpts, config.WithSharedConfigProfile(*profile))
+	}
+	if *endpointURL != "" {
+		cfgOpts = append(cfgOpts, config.WithBaseEndpoint(*endpointURL))
+	}
+	var client multipart.SecretsManagerClient
+	if *backend == "file" {
+		client, err = multipart.NewFileClient(*backendDir)
+		if err != nil {
+			return fail(err)
+		}
+	} else {
+		client, err = newSecretsManagerClient(ctx, cfgOpts...)
+	}
+	if err != nil {
+		if *profile != "" {
+			return fail(fmt.Errorf("failed to load AWS config for profile '%s': %w", *profile, err))
+		}
+		return fail(fmt.Errorf("failed to load AWS config: %w", err))
+	}
+	if *callRate > 0 {
+		client = multipart.NewRateLimitedClient(client, *callRate)
+	}
+	sm := multipart.NewSecretManager(client, partLimit)
+	sm.Naming = naming
+	sm.SyncTags = *syncTags
+	sm.KmsKeyID = *kmsKeyID
+	sm.Description = func(name string) string {
+		return partDescription(naming, *description, name, baseSecretName, copyTarget)
+	}
+	sm.SyncDescription = *syncDescription
+	sm.ForceDelete = *forceDelete
+	sm.Compact = *compact
+	sm.WriteConcurrency = *writeConcurrency
+	sm.VersionStage = *versionStage
+	sm.MoveStage = *moveStage
+	sm.ReplicaRegions = replicaRegions
+	sm.SkipMissingParts = *missingParts == "lenient"
+	if !restoreMode {
+		// A restore uploads the backed up values verbatim, which only the AWS limit applies to
+		sm.MaxSecretSize = *maxSecretSize
+	}
+	sm.Progress = infoOut
+	if *auditLogFile != "" {
+		identity := localIdentity()
+		if *backend != "file" {
+			identity, err = callerIdentity(ctx, cfgOpts...)
+			if err != nil {
+				return fail(fmt.Errorf("failed to look up the caller identity for --audit-log: %w", err))
+			}
+		}
+		audit, err := openAuditLog(*auditLogFile, identity, naming, baseSecretName, copyTarget)
+		if err != nil {
+			return fail(err)
+		}
+		defer audit.Close()
+		sm.Audit = audit.record
+	}
+
+	tags := map[string]string{
+		"temp:env":     *env,
+		"temp:feature": "multipart_secrets",
+	}
+	for k, v := range extraTags {
+		tags[k] = v
+	}
+
+	// Check if base secret exists before proceeding
+	_, err = client.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{
+		SecretId: aws.String(baseSecretName),
+	})
+	baseCreated := false
+	if err != nil {
+		var notFound *types.ResourceNotFoundException
+		switch {
+		case errors.As(err, &notFound) && *initBase && *dryRun:
+			fmt.Fprintf(infoOut, "Base secret '%s' does not exist and would be created\n", baseSecretName)
+			baseCreated = true
+		case errors.As(err, &notFound) && *initBase:
+			if err := sm.CreateOrModifySecret(ctx, baseSecretName, map[string]interface{}{}, tags, ""); err != nil {
+				return fail(fmt.Errorf("failed to create base secret '%s': %w", baseSecretName, err))
+			}
+			fmt.Fprintf(infoOut, "Created base secret '%s'\n", baseSecretName)
+			baseCreated = true
+		case errors.As(err, &notFound) && *onlyIfExists:
+			fmt.Fprintf(infoOut, "Base secret '%s' does not exist, nothing to do (--only-if-exists)\n", baseSecretName)
+			return 0
+		case errors.As(err, &notFound):
+			return fail(multipart.WithCode(multipart.CodeSecretNotFound, "", fmt.Errorf("Base secret '%s' does not exist. Please create the secret first before adding keys (or use --init).", baseSecretName)))
+		default:
+			return fail(fmt.Errorf("failed to describe base secret '%s': %w", baseSecretName, err))
+		}
+	}
+
+	if *watchMode {
+		// Polls run on the signal context: the watch lasts until interrupted and each poll gets its own --timeout
+		if err := watchParts(sigCtx, stdout, stderr, sm, baseSecretName, *noMultipart, *watchInterval, *timeout); err != nil {
+			return fail(err)
+		}
+		return 0
+	}
+
+	if *lockMode && !*dryRun {
+		lockBase := baseSecretName
+		if copyMode {
+			lockBase = copyTarget
+		}
+		lock, err := acquireLock(ctx, infoOut, sm, lockBase, *lockTTL)
+		if err != nil {
+			return fail(err)
+		}
+		defer func() {
+			if err := lock.Release(ctx); err != nil {
+				fmt.Fprintf(stderr, "WARNING: %v; it expires at %s\n", err, lock.ExpiresAt.Format(time.RFC3339))
+			}
+		}()
+	}
+
+	// Fetch multipart numbers once and reuse for both FetchAllSecretData and RedistributeSecrets
+	numbers := []int{0}
+	switch {
+	case len(partNumbers) > 0:
+		// The parts are named directly, so the set is not listed
+		slices.Sort(partNumbers)
+		numbers = partNumbers
+	case !*noMultipart:
+		start := time.Now()
+		numbers, err = sm.GetMultipartNumbers(ctx, baseSecretName)
+		timer.track("list", start)
+		if err != nil {
+			return fail(fmt.Errorf("failed to get multipart numbers: %w", err))
+		}
+	}
+	if baseCreated && *dryRun {
+		// The base does not exist yet; treat it as an empty set and let the dry run report it as created
+		numbers = nil
+	}
+
+	inv := &invocation{ctx: ctx, sm: sm, base: baseSecretName, numbers: numbers, stdout: stdout, stderr: stderr, summaryOut: summaryOut, jsonOutput: jsonOutput, quiet: *quiet, timer: timer, writeResult: writeResult, fail: fail}
+	chunkOpts := multipart.ChunkOptions{MaxSize: *maxSecretSize, Strategy: *packStrategy, KeyOrder: *keyOrder, Compact: *compact, Pins: pins}
+	switch {
+	case jsonPathExpr != nil:
+		return runJSONPath(inv, jsonPathExpr, *jsonPathQuery, *getValueMode, encryptionKey)
+	case *findKeyMode:
+		return runFindKey(inv, paths, *keyPrefix, *allOccurrences)
+	case findValueMode:
+		return runFindValue(inv, *findValueStr, *containsMatch, *keyPrefix)
+	case *getValueMode:
+		return runGetValue(inv, *jsonPath, encryptionKey)
+	case *verifyMode:
+		return runVerify(inv, *maxSecretSize)
+	case *describeMode:
+		return runDescribe(inv)
+	case *countMode:
+		return inv.exit(countKeys(ctx, stdout, sm, baseSecretName, numbers, *verbose))
+	case exportMode:
+		return inv.exit(exportSecretData(ctx, stdout, sm, baseSecretName, numbers, *exportFile, *keyOrder, encryptionKey))
+	case *exportEnvMode:
+		return inv.exit(exportEnv(ctx, stdout, sm, baseSecretName, numbers, *recursive, encryptionKey))
+	case copyMode:
+		return inv.exit(copySecretSet(ctx, stdout, sm, baseSecretName, numbers, copyTarget, tags, chunkOpts, partLimit, *forceUpdate, *dryRun, *pruneEmptyParts, prompt))
+	case restoreMode:
+		return inv.exit(restoreBackup(ctx, stdout, sm, *restoreDir, baseSecretName, numbers, tags, *dryRun, *pruneEmptyParts, prompt))
+	case *listKeysMode:
+		return inv.exit(listKeys(ctx, stdout, sm, baseSecretName, numbers, *recursive, *keyPrefix))
+	}
+
+	newValue := *setValue
+	if *setValueFile != "" {
+		content, err := os.ReadFile(*setValueFile)
+		if err != nil {
+			return fail(fmt.Errorf("failed to read value file: %w", err))
+		}
+		if !utf8.Valid(content) {
+			return fail(multipart.WithCode(multipart.CodeUsage, "", fmt.Errorf("value file '%s' is not valid UTF-8 and cannot be stored as a JSON string", *setValueFile)))
+		}
+		newValue = string(content)
+	}
+
+	var newData map[string]interface{}
+	var input string
+	if jsonInput || importMode || mergePatchMode {
+		file := *jsonFile
+		switch {
+		case importMode:
+			file = *importFile
+		case mergePatchMode:
+			file = *mergePatchFile
+		}
+		input, err = readInput(stdin, !importMode && (*mergePatchFile == "-" || *jsonData == "-"), file, *jsonData)
+		if err != nil {
+			return fail(err)
+		}
+		newData, err = multipart.ParseJSONInput(input, *strictKeys)
+		if err != nil {
+			return fail(err)
+		}
+		if *stringifyValues {
+			if newData, err = multipart.StringifyValues(input); err != nil {
+				return fail(err)
+			}
+		}
+		if *validateNested {
+			if err := validateNestedJSON(newData, ""); err != nil {
+				return fail(err)
+			}
+		}
+	}
+	if len(binaryKeys) > 0 {
+		if newData == nil {
+			newData = map[string]interface{}{}
+		}
+		if err := addBinaryKeys(newData, binaryKeys); err != nil {
+			return fail(err)
+		}
+	}
+
+	// Each write mode only supplies its edit; fetching, chunking and writing are shared
+	var e *edit
+	inputPath := *jsonPath
+	switch {
+	case importMode:
+		e, inputPath = importEdit(newData), ""
+	case setMode:
+		e = setEdit(*setKey, newValue, *preserveTypes)
+	case appendMode:
+		e = appendEdit(*appendTo, newValue)
+	case *deleteKeyMode:
+		e = deleteKeysEdit(paths)
+	case deletePrefixMode:
+		e = deletePrefixEdit(*deletePrefix)
+	case *normalizeMode:
+		e = normalizeEdit()
+		if !explicit["pack-strategy"] {
+			chunkOpts.Strategy = multipart.PackStrategyCompact
+		}
+	case mergePatchMode:
+		e = mergePatchEdit(newData)
+	case hasInput:
+		e = addEdit(newData, *jsonPath, *dottedKeys, multipart.AddOptions{ForceUpdate: *forceUpdate, SkipExisting: *skipExisting, Merge: *merge, PreserveTypes: *preserveTypes, EncryptionKey: encryptionKey, Log: infoOut})
+	}
+	lockName := ""
+	if *lockMode {
+		lockName = naming.LockName(baseSecretName)
+	}
+	return runWrite(inv, e, writeConfig{
+		chunkOpts:          chunkOpts,
+		maxParts:           *maxParts,
+		maxKeys:            *maxKeys,
+		schema:             schema,
+		encryptionKey:      encryptionKey,
+		encryptPaths:       encryptPaths,
+		input:              input,
+		inputPath:          inputPath,
+		preserveOrder:      *preserveOrder,
+		reportDuplicates:   *reportDuplicates,
+		duplicatePolicy:    *duplicatePolicy,
+		noMultipart:        *noMultipart,
+		fullRedistribute:   *fullRedistribute,
+		dominantThreshold:  *dominantThreshold,
+		prune:              *pruneEmptyParts,
+		dryRun:             *dryRun,
+		verbose:            *verbose,
+		lockName:           lockName,
+		identity:           *auditLogFile != "" && *backend != "file",
+		prompt:             prompt,
+		backupDir:          *backupDir,
+		noConcurrencyCheck: *noConcurrencyCheck,
+		noRollback:         *noRollback,
+		tags:               tags,
+	})
 }
\ No newline at end of file
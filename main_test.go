@@ -0,0 +1,616 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+
+	"secret-manager/multipart"
+	"secret-manager/multipart/multiparttest"
+)
+
+// useFakeClient makes run talk to client for the rest of the test
+func useFakeClient(t *testing.T, client *multiparttest.Client) {
+	t.Helper()
+	saved := newSecretsManagerClient
+	newSecretsManagerClient = func(ctx context.Context, optFns ...func(*config.LoadOptions) error) (multipart.SecretsManagerClient, error) {
+		return client, nil
+	}
+	t.Cleanup(func() { newSecretsManagerClient = saved })
+}
+
+// compactJSON re-marshals the JSON document js without indentation and with sorted keys
+func compactJSON(t *testing.T, js string) string {
+	t.Helper()
+	var data interface{}
+	if err := json.Unmarshal([]byte(js), &data); err != nil {
+		t.Fatalf("invalid JSON %q: %v", js, err)
+	}
+	out, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+// storedKeys returns the sorted top-level keys of every secret of client
+func storedKeys(t *testing.T, client *multiparttest.Client) []string {
+	t.Helper()
+	var keys []string
+	for _, name := range client.Names() {
+		value, _ := client.Value(name)
+		var data map[string]interface{}
+		if err := json.Unmarshal([]byte(value), &data); err != nil {
+			t.Fatalf("%s holds invalid JSON: %v", name, err)
+		}
+		for k := range data {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestDottedKeyPaths(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		// output must appear on stdout
+		output string
+		want   string
+	}{
+		{name: "find an escaped key", args: []string{"--find-key", "--json_path", `db\.prod.user`}, output: `Key 'db\.prod.user' found in: app`},
+		{name: "find the nested key", args: []string{"--find-key", "--json_path", "db.prod.user"}, output: "Key 'db.prod.user' found in: app"},
+		{name: "get an escaped key", args: []string{"--get-value", "--json_path", `db\.prod.user`}, output: "dotted\n"},
+		{name: "delete an escaped key", args: []string{"--delete-key", "--json_path", `db\.prod.user`, "--yes"}, want: `{"db":{"prod":{"user":"nested"}},"db.prod":{}}`},
+		{name: "delete the nested key", args: []string{"--delete-key", "--json_path", "db.prod.user", "--yes"}, want: `{"db":{"prod":{}},"db.prod":{"user":"dotted"}}`},
+		{name: "add under an escaped key", args: []string{"--json_data", `{"pass":"p"}`, "--json_path", `db\.prod`, "--yes"}, want: `{"db":{"prod":{"user":"nested"}},"db.prod":{"pass":"p","user":"dotted"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := multiparttest.NewClient()
+			client.Put("app", `{"db":{"prod":{"user":"nested"}},"db.prod":{"user":"dotted"}}`)
+			useFakeClient(t, client)
+			var stdout, stderr bytes.Buffer
+			args := append([]string{"--env", "dev", "--secret_name", "app"}, tt.args...)
+			if code := run(args, strings.NewReader(""), &stdout, &stderr); code != 0 {
+				t.Fatalf("exit code = %d, want 0\nstdout:\n%s\nstderr:\n%s", code, stdout.String(), stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tt.output) {
+				t.Errorf("stdout does not contain %q:\n%s", tt.output, stdout.String())
+			}
+			if tt.want != "" {
+				if got, _ := client.Value("app"); compactJSON(t, got) != tt.want {
+					t.Errorf("app = %s, want %s", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPinNeedsContiguousParts(t *testing.T) {
+	tests := []struct {
+		name  string
+		parts []string
+		code  int
+		err   string
+	}{
+		{name: "gap in the numbers", parts: []string{"app", "app-1", "app-3"}, code: exitUsage, err: "'app-2' is missing"},
+		{name: "gap after the base", parts: []string{"app", "app-2"}, code: exitUsage, err: "'app-1' is missing"},
+		{name: "contiguous numbers", parts: []string{"app", "app-1", "app-2"}, code: exitOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := multiparttest.NewClient()
+			for i, name := range tt.parts {
+				client.Put(name, fmt.Sprintf(`{"k%d":"%s"}`, i, strings.Repeat("x", 60)))
+			}
+			useFakeClient(t, client)
+			args := []string{"--env", "dev", "--secret_name", "app", "--json_data", `{"x":"1"}`, "--pin", "x=2", "--max-secret-size", "100", "--compact", "--yes"}
+			var stdout, stderr bytes.Buffer
+			if code := run(args, strings.NewReader(""), &stdout, &stderr); code != tt.code {
+				t.Fatalf("exit code = %d, want %d\nstdout:\n%s\nstderr:\n%s", code, tt.code, stdout.String(), stderr.String())
+			}
+			if !strings.Contains(stderr.String(), tt.err) {
+				t.Errorf("stderr does not contain %q:\n%s", tt.err, stderr.String())
+			}
+			if tt.code == exitOK {
+				if got, _ := client.Value("app-2"); !strings.Contains(got, `"x":"1"`) {
+					t.Errorf("app-2 = %s, want it to hold the pinned key", got)
+				}
+			}
+		})
+	}
+}
+
+func TestNormalizePackStrategy(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		parts int
+	}{
+		{name: "flag defaults to compact", args: []string{"--normalize"}, parts: 3},
+		{name: "flag keeps an explicit strategy", args: []string{"--normalize", "--pack-strategy", "alpha"}, parts: 4},
+		{name: "subcommand defaults to compact", args: []string{"normalize"}, parts: 3},
+		{name: "subcommand keeps an explicit strategy", args: []string{"normalize", "--pack-strategy", "alpha"}, parts: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := map[string]interface{}{}
+			for k, n := range map[string]int{"a": 70, "b": 40, "c": 10, "d": 70, "e": 40} {
+				data[k] = strings.Repeat("x", n)
+			}
+			chunks, err := multipart.ChunkDataIntoSecrets(data, multipart.ChunkOptions{MaxSize: 100, Strategy: multipart.PackStrategyAlpha, Compact: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := multiparttest.NewClient()
+			for i, chunk := range chunks {
+				js, _ := json.Marshal(chunk)
+				client.Put(multipart.DefaultNaming.PartName("app", i), string(js))
+			}
+			useFakeClient(t, client)
+			args := append(append([]string{"--env", "dev", "--secret_name", "app"}, tt.args...), "--max-secret-size", "100", "--compact", "--prune-empty-parts", "--yes")
+			var stdout, stderr bytes.Buffer
+			if code := run(args, strings.NewReader(""), &stdout, &stderr); code != exitOK {
+				t.Fatalf("exit code = %d\nstdout:\n%s\nstderr:\n%s", code, stdout.String(), stderr.String())
+			}
+			if got := len(client.Names()); got != tt.parts {
+				t.Errorf("%d parts after normalize, want %d", got, tt.parts)
+			}
+		})
+	}
+}
+
+func TestExportEnvDecodesBinary(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		code    int
+		output  string
+		err     string
+	}{
+		{name: "text content is decoded", content: "-----BEGIN CERTIFICATE-----\n", code: exitOK, output: "export CERT='-----BEGIN CERTIFICATE-----\n'"},
+		{name: "NUL bytes are refused", content: "a\x00b", code: exitFailure, err: "NUL bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := multiparttest.NewClient()
+			client.Put("app", fmt.Sprintf(`{"cert":"%s"}`, multipart.EncodeBinary([]byte(tt.content))))
+			useFakeClient(t, client)
+			var stdout, stderr bytes.Buffer
+			args := []string{"--env", "dev", "--secret_name", "app", "--export-env"}
+			if code := run(args, strings.NewReader(""), &stdout, &stderr); code != tt.code {
+				t.Fatalf("exit code = %d, want %d\nstderr:\n%s", code, tt.code, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tt.output) {
+				t.Errorf("stdout does not contain %q:\n%s", tt.output, stdout.String())
+			}
+			if !strings.Contains(stderr.String(), tt.err) {
+				t.Errorf("stderr does not contain %q:\n%s", tt.err, stderr.String())
+			}
+		})
+	}
+}
+
+func TestSubcommandFlagCombinations(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		code int
+		err  string
+	}{
+		{name: "--lock-ttl without --lock", args: []string{"--json_data", `{"b":"2"}`, "--lock-ttl", "10m", "--yes"}, code: exitUsage, err: "--lock-ttl can only be used with --lock"},
+		{name: "--lock-ttl without --lock after add", args: []string{"add", "--json_data", `{"b":"2"}`, "--lock-ttl", "10m", "--yes"}, code: exitUsage, err: "--lock-ttl can only be used with --lock"},
+		{name: "--lock-ttl with --lock after add", args: []string{"add", "--json_data", `{"b":"2"}`, "--lock", "--lock-ttl", "10m", "--yes"}, code: exitOK},
+		{name: "--interval without --watch", args: []string{"--list-keys", "--interval", "1s"}, code: exitUsage, err: "--interval can only be used with --watch"},
+		{name: "unknown subcommand with --errors json", args: []string{"--errors", "json", "rename"}, code: exitUsage, err: `{"code":"USAGE","message":"unknown subcommand 'rename'`},
+		{name: "argument after subcommand with --errors json", args: []string{"--errors", "json", "find", "--json_path", "a", "extra"}, code: exitUsage, err: `{"code":"USAGE","message":"unexpected argument 'extra'`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := multiparttest.NewClient()
+			client.Put("app", `{"a":"1"}`)
+			useFakeClient(t, client)
+			args := append([]string{"--env", "dev", "--secret_name", "app"}, tt.args...)
+			var stdout, stderr bytes.Buffer
+			if code := run(args, strings.NewReader(""), &stdout, &stderr); code != tt.code {
+				t.Fatalf("exit code = %d, want %d\nstderr:\n%s", code, tt.code, stderr.String())
+			}
+			if !strings.Contains(stderr.String(), tt.err) {
+				t.Errorf("stderr does not contain %q:\n%s", tt.err, stderr.String())
+			}
+		})
+	}
+}
+
+func TestRunExitCodes(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		code int
+		// keys are the keys stored across the parts after the run
+		keys []string
+		// output must appear on stdout
+		output string
+	}{
+		{name: "add a new key", args: []string{"--json_data", `{"c":"3"}`, "--yes"}, code: exitOK, keys: []string{"a", "b", "c"}},
+		{name: "add an existing key", args: []string{"--json_data", `{"b":"9"}`, "--yes"}, code: exitConflict, keys: []string{"a", "b"}},
+		{name: "add subcommand", args: []string{"add", "--json_data", `{"c":"3"}`, "--yes"}, code: exitOK, keys: []string{"a", "b", "c"}},
+		{name: "find an existing key", args: []string{"--find-key", "--json_path", "b"}, code: exitOK, keys: []string{"a", "b"}, output: "found in: app"},
+		{name: "find subcommand", args: []string{"find", "--json_path", "b"}, code: exitOK, keys: []string{"a", "b"}, output: "found in: app"},
+		{name: "find a missing key", args: []string{"--find-key", "--json_path", "nope", "--quiet"}, code: exitNotFound, keys: []string{"a", "b"}},
+		{name: "delete a key", args: []string{"--delete-key", "--json_path", "b", "--yes"}, code: exitOK, keys: []string{"a"}},
+		{name: "delete a missing key", args: []string{"--delete-key", "--json_path", "nope", "--yes"}, code: exitNotFound, keys: []string{"a", "b"}},
+		{name: "find without a path", args: []string{"--find-key"}, code: exitUsage, keys: []string{"a", "b"}},
+		{name: "unknown subcommand", args: []string{"rename"}, code: exitUsage, keys: []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := multiparttest.NewClient()
+			client.Put("app", `{"a":"1","b":"2"}`)
+			useFakeClient(t, client)
+			args := append([]string{"--env", "dev", "--secret_name", "app"}, tt.args...)
+			var stdout, stderr bytes.Buffer
+			if code := run(args, strings.NewReader(""), &stdout, &stderr); code != tt.code {
+				t.Fatalf("exit code = %d, want %d\nstdout:\n%s\nstderr:\n%s", code, tt.code, stdout.String(), stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tt.output) {
+				t.Errorf("stdout does not contain %q:\n%s", tt.output, stdout.String())
+			}
+			if got := storedKeys(t, client); !slices.Equal(got, tt.keys) {
+				t.Errorf("stored keys = %v, want %v", got, tt.keys)
+			}
+		})
+	}
+}
+
+func TestRunConfirm(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer string
+		code   int
+		keys   []string
+	}{
+		{name: "yes writes", answer: "y\n", code: exitOK, keys: []string{"a", "b"}},
+		{name: "no aborts", answer: "n\n", code: exitFailure, keys: []string{"a"}},
+		{name: "no answer aborts", answer: "", code: exitFailure, keys: []string{"a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := multiparttest.NewClient()
+			client.Put("app", `{"a":"1"}`)
+			useFakeClient(t, client)
+			var stdout, stderr bytes.Buffer
+			args := []string{"--env", "dev", "--secret_name", "app", "--json_data", `{"b":"2"}`}
+			if code := run(args, strings.NewReader(tt.answer), &stdout, &stderr); code != tt.code {
+				t.Fatalf("exit code = %d, want %d\nstderr:\n%s", code, tt.code, stderr.String())
+			}
+			if !strings.Contains(stderr.String(), "Proceed? [y/N]") {
+				t.Errorf("prompt not written to stderr:\n%s", stderr.String())
+			}
+			if got := storedKeys(t, client); !slices.Equal(got, tt.keys) {
+				t.Errorf("stored keys = %v, want %v", got, tt.keys)
+			}
+		})
+	}
+}
+
+func TestGetValueDecodesBinary(t *testing.T) {
+	content := "\x00\x01binary\xff"
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "--get-value", args: []string{"--get-value", "--json_path", "files.blob"}, want: content},
+		{name: "--jsonpath", args: []string{"--get-value", "--jsonpath", "$.files.blob"}, want: content},
+		{name: "--jsonpath with text values", args: []string{"--get-value", "--jsonpath", "$.files.name"}, want: "blob.bin\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := multiparttest.NewClient()
+			client.Put("app", fmt.Sprintf(`{"files":{"blob":"%s","name":"blob.bin"}}`, multipart.EncodeBinary([]byte(content))))
+			useFakeClient(t, client)
+			args := append([]string{"--env", "dev", "--secret_name", "app"}, tt.args...)
+			var stdout, stderr bytes.Buffer
+			if code := run(args, strings.NewReader(""), &stdout, &stderr); code != exitOK {
+				t.Fatalf("exit code = %d\nstderr:\n%s", code, stderr.String())
+			}
+			if stdout.String() != tt.want {
+				t.Errorf("stdout = %q, want %q", stdout.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestDryRunPlan(t *testing.T) {
+	saved := callerIdentity
+	callerIdentity = func(ctx context.Context, optFns ...func(*config.LoadOptions) error) (string, error) {
+		return "arn:aws:iam::123456789012:user/test", nil
+	}
+	t.Cleanup(func() { callerIdentity = saved })
+	client := multiparttest.NewClient()
+	client.Put("app", `{"a":"1"}`)
+	useFakeClient(t, client)
+	args := []string{"--env", "dev", "--secret_name", "app", "--json_data", `{"b":"2"}`, "--dry-run", "--verbose", "--output", "json",
+		"--lock", "--replica-region", "eu-west-1", "--audit-log", filepath.Join(t.TempDir(), "audit.log")}
+	var stdout, stderr bytes.Buffer
+	if code := run(args, strings.NewReader(""), &stdout, &stderr); code != exitOK {
+		t.Fatalf("exit code = %d\nstderr:\n%s", code, stderr.String())
+	}
+	var result operationResult
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		t.Fatalf("invalid result %q: %v", stdout.String(), err)
+	}
+	var got []string
+	for _, call := range result.Plan {
+		got = append(got, strings.TrimSpace(call.Operation+" "+call.SecretID))
+	}
+	want := []string{
+		"GetCallerIdentity",
+		"DescribeSecret app-lock",
+		"CreateSecret app-lock",
+		"UpdateSecretVersionStage app-lock",
+		"DescribeSecret app",
+		"UpdateSecret app",
+		"ReplicateSecretToRegions app",
+		"UpdateSecretVersionStage app-lock",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("plan = %q, want %q", got, want)
+	}
+	if calls := client.Calls("UpdateSecret") + client.Calls("CreateSecret"); calls != 0 {
+		t.Errorf("dry run made %d write call(s)", calls)
+	}
+}
+
+func TestRefuseEmptyParts(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		// err must appear on stderr when set; otherwise the run must succeed
+		err string
+	}{
+		{name: "delete", args: []string{"--delete-key", "--json_path", "b"}, err: "after deleting 'b' the keys fit in 1 secret(s) but 2 multipart secrets exist"},
+		{name: "delete prefix", args: []string{"--delete-prefix", "b"}, err: "after deleting the keys under 'b' the keys fit"},
+		{name: "merge patch", args: []string{"--merge-patch", "-"}, err: "after applying the merge patch the keys fit"},
+		{name: "pruned", args: []string{"--delete-key", "--json_path", "b", "--prune-empty-parts"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := multiparttest.NewClient()
+			client.Put("app", `{"a":"1"}`)
+			client.Put("app-1", `{"b":"2"}`)
+			useFakeClient(t, client)
+			args := append([]string{"--env", "dev", "--secret_name", "app", "--yes"}, tt.args...)
+			var stdout, stderr bytes.Buffer
+			code := run(args, strings.NewReader(`{"b":null}`), &stdout, &stderr)
+			if tt.err == "" {
+				if code != exitOK {
+					t.Fatalf("exit code = %d\nstderr:\n%s", code, stderr.String())
+				}
+				if got := storedKeys(t, client); !slices.Equal(got, []string{"a"}) {
+					t.Errorf("stored keys = %v, want [a]", got)
+				}
+				return
+			}
+			if code == exitOK || !strings.Contains(stderr.String(), tt.err) {
+				t.Fatalf("exit code = %d, stderr does not contain %q:\n%s", code, tt.err, stderr.String())
+			}
+			if got := storedKeys(t, client); !slices.Equal(got, []string{"a", "b"}) {
+				t.Errorf("stored keys = %v, want [a b]", got)
+			}
+		})
+	}
+}
+
+func TestEncryptedValuesStayEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	writeKey := func(name string, key []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	key := bytes.Repeat([]byte{7}, 32)
+	keyFile, wrongKeyFile := writeKey("key", key), writeKey("wrong", bytes.Repeat([]byte{8}, 32))
+	tests := []struct {
+		name string
+		args []string
+		// err must appear on stderr when set; otherwise the run must succeed
+		err string
+		// want is the decrypted value of pw after the run
+		want string
+		// writes is the number of UpdateSecret calls made
+		writes int
+	}{
+		{name: "encrypt a new key", args: []string{"--json_data", `{"pw2":"new"}`, "--encrypt-keys", "pw2", "--encryption-key-file", keyFile}, want: "old", writes: 1},
+		{name: "overwrite re-encrypts", args: []string{"--json_data", `{"pw":"new"}`, "--force_update", "--encryption-key-file", keyFile}, want: "new", writes: 1},
+		{name: "set re-encrypts", args: []string{"--set-key", "pw", "--value", "new", "--encryption-key-file", keyFile}, want: "new", writes: 1},
+		{name: "overwrite without the key fails", args: []string{"--json_data", `{"pw":"new"}`, "--force_update"}, err: "key 'pw' is stored encrypted; pass --encryption-key-file", want: "old"},
+		{name: "skip-existing compares the plaintext", args: []string{"--json_data", `{"pw":"old"}`, "--skip-existing", "--encryption-key-file", keyFile}, want: "old"},
+		{name: "wrong key fails", args: []string{"--json_data", `{"pw":"new"}`, "--force_update", "--encryption-key-file", wrongKeyFile}, err: "stored key 'pw' cannot be decrypted", want: "old"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sealed, err := multipart.EncryptValue("old", key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := multiparttest.NewClient()
+			client.Put("app", fmt.Sprintf(`{"pw":"%s","user":"u"}`, sealed))
+			useFakeClient(t, client)
+			args := append([]string{"--env", "dev", "--secret_name", "app", "--yes"}, tt.args...)
+			var stdout, stderr bytes.Buffer
+			code := run(args, strings.NewReader(""), &stdout, &stderr)
+			switch {
+			case tt.err == "" && code != exitOK:
+				t.Fatalf("exit code = %d\nstderr:\n%s", code, stderr.String())
+			case tt.err != "" && (code == exitOK || !strings.Contains(stderr.String(), tt.err)):
+				t.Fatalf("exit code = %d, stderr does not contain %q:\n%s", code, tt.err, stderr.String())
+			}
+			if got := client.Calls("UpdateSecret"); got != tt.writes {
+				t.Errorf("UpdateSecret calls = %d, want %d", got, tt.writes)
+			}
+			stored, _ := client.Value("app")
+			var data map[string]interface{}
+			if err := json.Unmarshal([]byte(stored), &data); err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range data {
+				if k != "user" && !multipart.IsEncrypted(v) {
+					t.Errorf("%s is stored as plaintext %v", k, v)
+				}
+			}
+			pw, err := multipart.DecryptValue(data["pw"].(string), key)
+			if err != nil || pw != tt.want {
+				t.Errorf("pw decrypts to %v, %v, want %s", pw, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalizedWrite(t *testing.T) {
+	tests := []struct {
+		name  string
+		parts map[string]string
+		args  []string
+		// only is the part written alone, "" when every part is rewritten
+		only string
+		want map[string]string
+		code int
+	}{
+		{
+			name:  "a change to one part writes it alone",
+			parts: map[string]string{"app": `{"a":"1","b":"2"}`, "app-1": `{"c":"3"}`},
+			args:  []string{"--json_data", `{"c":"9"}`, "--force_update"},
+			only:  "app-1",
+			want:  map[string]string{"app": `{"a":"1","b":"2"}`, "app-1": `{"c":"9"}`},
+		},
+		{
+			name:  "a new key goes to the last part with room",
+			parts: map[string]string{"app": `{"a":"1"}`, "app-1": `{"c":"3"}`},
+			args:  []string{"--json_data", `{"d":"4"}`},
+			only:  "app-1",
+			want:  map[string]string{"app": `{"a":"1"}`, "app-1": `{"c":"3","d":"4"}`},
+		},
+		{
+			name:  "a new key skips a full last part",
+			parts: map[string]string{"app": `{"a":"1"}`, "app-1": `{"c":"3333333333"}`},
+			args:  []string{"--json_data", `{"d":"4"}`, "--max-secret-size", "20"},
+			only:  "app",
+			want:  map[string]string{"app": `{"a":"1","d":"4"}`, "app-1": `{"c":"3333333333"}`},
+		},
+		{
+			name:  "a part that would overflow repacks everything",
+			parts: map[string]string{"app": `{"a":"1"}`, "app-1": `{"c":"3","e":"5"}`},
+			args:  []string{"--json_data", `{"e":"55555"}`, "--force_update", "--max-secret-size", "20"},
+			want:  map[string]string{"app": `{"a":"1","c":"3"}`, "app-1": `{"e":"55555"}`},
+		},
+		{
+			name:  "a part that would empty repacks everything",
+			parts: map[string]string{"app": `{"a":"1"}`, "app-1": `{"c":"3"}`},
+			args:  []string{"--delete-key", "--json_path", "c", "--prune-empty-parts"},
+			want:  map[string]string{"app": `{"a":"1"}`},
+		},
+		{
+			name:  "--full-redistribute rewrites every part",
+			parts: map[string]string{"app": `{"a":"1","b":"2"}`, "app-1": `{"c":"3"}`},
+			args:  []string{"--json_data", `{"c":"9"}`, "--force_update", "--full-redistribute", "--max-secret-size", "20"},
+			want:  map[string]string{"app": `{"a":"1","b":"2"}`, "app-1": `{"c":"9"}`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := multiparttest.NewClient()
+			for name, value := range tt.parts {
+				client.Put(name, value)
+			}
+			useFakeClient(t, client)
+			args := append([]string{"--env", "dev", "--secret_name", "app", "--compact", "--yes"}, tt.args...)
+			var stdout, stderr bytes.Buffer
+			if code := run(args, strings.NewReader(""), &stdout, &stderr); code != exitOK {
+				t.Fatalf("exit code = %d\nstdout:\n%s\nstderr:\n%s", code, stdout.String(), stderr.String())
+			}
+			notice := fmt.Sprintf("Only '%s' changes; writing it alone", tt.only)
+			if got := strings.Contains(stdout.String(), "writing it alone"); got != (tt.only != "") || (got && !strings.Contains(stdout.String(), notice)) {
+				t.Errorf("stdout does not match a write of %q alone:\n%s", tt.only, stdout.String())
+			}
+			writes := len(tt.want)
+			if tt.only != "" {
+				writes = 1
+			}
+			if got := client.Calls("UpdateSecret"); got != writes {
+				t.Errorf("UpdateSecret calls = %d, want %d", got, writes)
+			}
+			got := make(map[string]string)
+			for _, name := range client.Names() {
+				value, _ := client.Value(name)
+				got[name] = compactJSON(t, value)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("parts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalizedWriteChecksOtherParts(t *testing.T) {
+	client := multiparttest.NewClient()
+	client.Put("app", `{"a":"1","b":"2"}`)
+	client.Put("app-1", `{"c":"3"}`)
+	// Another run changes app after it was read; the write of app-1 alone must notice
+	var once sync.Once
+	client.Intercept = func(op, name string) error {
+		if op == "DescribeSecret" && name == "app" {
+			once.Do(func() { client.Put("app", `{"a":"1","b":"2","c":"7"}`) })
+		}
+		return nil
+	}
+	useFakeClient(t, client)
+	args := []string{"--env", "dev", "--secret_name", "app", "--compact", "--yes", "--json_data", `{"c":"9"}`, "--force_update"}
+	var stdout, stderr bytes.Buffer
+	if code := run(args, strings.NewReader(""), &stdout, &stderr); code != exitConflict {
+		t.Fatalf("exit code = %d, want %d\nstderr:\n%s", code, exitConflict, stderr.String())
+	}
+	if got, _ := client.Value("app-1"); got != `{"c":"3"}` {
+		t.Errorf("app-1 = %s, want it unchanged", got)
+	}
+	if calls := client.Calls("UpdateSecret"); calls != 0 {
+		t.Errorf("UpdateSecret calls = %d, want 0", calls)
+	}
+}
+
+func TestRunWaitsForHeldLock(t *testing.T) {
+	client := multiparttest.NewClient()
+	client.Put("app", `{"a":"1"}`)
+	if _, err := multipart.NewSecretManager(client, multipart.DefaultMaxParts).TryLock(context.Background(), "app", "other run", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	useFakeClient(t, client)
+	args := []string{"--env", "dev", "--secret_name", "app", "--yes", "--json_data", `{"b":"2"}`, "--lock", "--timeout", "200ms"}
+	var stdout, stderr bytes.Buffer
+	if code := run(args, strings.NewReader(""), &stdout, &stderr); code != exitConflict {
+		t.Fatalf("exit code = %d, want %d\nstderr:\n%s", code, exitConflict, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "gave up waiting: lock 'app-lock' is held by other run") {
+		t.Errorf("stderr does not name the holder:\n%s", stderr.String())
+	}
+	if got, _ := client.Value("app"); got != `{"a":"1"}` {
+		t.Errorf("app = %s, want it unchanged", got)
+	}
+}
@@ -0,0 +1,71 @@
+// Package multipart stores one JSON object in AWS Secrets Manager as a set of parts
+// (base, base-1, base-2, ...) that each stay below the per-secret size limit.
+// AddKeys is the high-level entrypoint; the lower-level functions parse input,
+// pack keys into parts and read or write the part set. They return errors and never print
+// to stdout or stderr; progress lines and warnings go only to the optional SecretManager.Progress writer
+package multipart
+
+import (
+	"context"
+	"fmt"
+)
+
+// AddKeysOptions controls AddKeys
+type AddKeysOptions struct {
+	AddOptions
+	// Path is the dot-notation path of the nested object to merge data into ("" for the top level)
+	Path string
+	// Chunk controls how the resulting keys are packed into parts; a zero MaxSize means MaxSecretSizeBytes
+	Chunk ChunkOptions
+	// Tags are applied to parts created by the write
+	Tags map[string]string
+}
+
+// AddKeys adds data to the multipart secret base, repacks the keys and writes the parts
+// It returns the number of keys that changed; nothing is written when that is 0
+// The parts are written with the concurrency check and rollback enabled
+func (sm *SecretManager) AddKeys(ctx context.Context, base string, data map[string]interface{}, opts AddKeysOptions) (int, error) {
+	numbers, err := sm.GetMultipartNumbers(ctx, base)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get multipart numbers: %w", err)
+	}
+	parts, err := sm.FetchSecretParts(ctx, base, numbers)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch existing secret data: %w", err)
+	}
+	all, err := MergeSecretParts(parts)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch existing secret data: %w", err)
+	}
+
+	var changed int
+	if opts.Path != "" {
+		changed, err = AddSecretToGivenPath(all, data, opts.Path, opts.AddOptions)
+	} else {
+		changed, err = AddKeyValues(all, data, opts.AddOptions)
+	}
+	if err != nil || changed == 0 {
+		return 0, err
+	}
+
+	if opts.Chunk.MaxSize == 0 {
+		opts.Chunk.MaxSize = MaxSecretSizeBytes
+	}
+	chunks, err := ChunkDataIntoSecrets(all, opts.Chunk)
+	if err != nil {
+		return 0, err
+	}
+	if err := CheckChunks(all, chunks); err != nil {
+		return 0, err
+	}
+	versions := make(map[string]string, len(parts))
+	previous := make(map[string]string, len(parts))
+	for _, part := range parts {
+		versions[part.Name] = part.VersionID
+		previous[part.Name] = part.Raw
+	}
+	if err := sm.RedistributeSecrets(ctx, base, chunks, opts.Tags, numbers, versions, previous); err != nil {
+		return 0, fmt.Errorf("failed to redistribute secrets: %w", err)
+	}
+	return changed, nil
+}
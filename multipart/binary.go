@@ -0,0 +1,32 @@
+package multipart
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// BinaryPrefix marks a string value as binary content encoded by EncodeBinary
+// The rest of the string is the standard base64 encoding of the content
+const BinaryPrefix = "bin:base64:"
+
+// IsBinary reports whether v is a value produced by EncodeBinary
+func IsBinary(v interface{}) bool {
+	s, ok := v.(string)
+	return ok && strings.HasPrefix(s, BinaryPrefix)
+}
+
+// EncodeBinary returns content as a marked base64 string that can be stored as a JSON value
+// The encoded form is what the chunker measures, so it is about 4/3 of the content size
+func EncodeBinary(content []byte) string {
+	return BinaryPrefix + base64.StdEncoding.EncodeToString(content)
+}
+
+// DecodeBinary returns the content of a value produced by EncodeBinary
+func DecodeBinary(s string) ([]byte, error) {
+	content, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, BinaryPrefix))
+	if err != nil {
+		return nil, errors.New("malformed binary value")
+	}
+	return content, nil
+}
@@ -0,0 +1,650 @@
+package multipart
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// MaxSecretSizeBytes is the default maximum size of a single part
+const MaxSecretSizeBytes = 50 * 1024
+
+// AWSMaxSecretSizeBytes is the hard ceiling AWS Secrets Manager enforces on a SecretString
+const AWSMaxSecretSizeBytes = 64 * 1024
+
+// SecretSize returns the size of a SecretString in bytes, as AWS measures it
+func SecretSize(data string) int {
+	return len([]byte(data))
+}
+
+// AddOptions controls how AddKeyValues and AddSecretToGivenPath treat keys that already exist
+type AddOptions struct {
+	// ForceUpdate overwrites existing keys and fails for missing ones
+	ForceUpdate bool
+	// SkipExisting skips keys whose stored value is already deep-equal to the new one
+	SkipExisting bool
+	// EncryptionKey decrypts encrypted stored values before they are compared with the new ones
+	EncryptionKey []byte
+	// Merge recursively merges new objects into existing objects, overwriting only scalar leaves
+	Merge bool
+	// PreserveTypes fails an overwrite that would change the JSON type of the stored value
+	PreserveTypes bool
+	// Log receives a line for every key overwritten; nil discards them
+	Log io.Writer
+}
+
+// logf writes a notice to o.Log if set
+func (o AddOptions) logf(format string, a ...interface{}) {
+	if o.Log != nil {
+		fmt.Fprintf(o.Log, format, a...)
+	}
+}
+
+// unchanged reports whether the stored value old already equals v; an encrypted old value is
+// compared by its plaintext when o.EncryptionKey can decrypt it
+func (o AddOptions) unchanged(old, v interface{}) bool {
+	if s, ok := old.(string); ok && o.EncryptionKey != nil && IsEncrypted(s) {
+		if plain, err := DecryptValue(s, o.EncryptionKey); err == nil {
+			old = plain
+		}
+	}
+	return reflect.DeepEqual(old, v)
+}
+
+// CheckSameType fails when replacing old with new at path would change its JSON type
+func CheckSameType(path string, old, new interface{}) error {
+	if oldKind, newKind := JSONKind(old), JSONKind(new); oldKind != newKind {
+		return WithCode(CodeTypeConflict, path, fmt.Errorf("cannot update '%s': existing value is %s but the new value is %s (--preserve-types)", path, oldKind, newKind))
+	}
+	return nil
+}
+
+// AddKeyValues merges new into all and returns the number of keys that changed
+func AddKeyValues(all map[string]interface{}, new map[string]interface{}, opts AddOptions) (int, error) {
+	if opts.Merge {
+		return mergeObjects(all, new, "", opts)
+	}
+	changed := make(map[string]interface{}, len(new))
+	for k, v := range new {
+		old, exists := all[k]
+		if opts.SkipExisting && exists && opts.unchanged(old, v) {
+			continue
+		}
+		if opts.ForceUpdate {
+			if !exists {
+				return 0, WithCode(CodeKeyNotFound, k, fmt.Errorf("key '%s' not found for update (use without --force_update to add new keys)", k))
+			}
+			if opts.PreserveTypes {
+				if err := CheckSameType(EscapePathSegment(k), old, v); err != nil {
+					return 0, err
+				}
+			}
+			opts.logf("Overwriting key '%s'\n", k)
+		} else {
+			if exists {
+				return 0, WithCode(CodeKeyExists, k, fmt.Errorf("key '%s' already exists (use --force_update to update existing keys)", k))
+			}
+		}
+		changed[k] = v
+	}
+	for k, v := range changed {
+		all[k] = v
+	}
+	return len(changed), nil
+}
+
+// mergeObjects recursively merges src into dst and returns the number of leaves that changed
+// Objects present on both sides are merged, any other existing value is overwritten, and an
+// object meeting a non-object is a conflict reported with its dot-notation path
+// With opts.PreserveTypes, overwriting a leaf with a value of another JSON type is a conflict too
+func mergeObjects(dst, src map[string]interface{}, path string, opts AddOptions) (int, error) {
+	changed := 0
+	for k, v := range src {
+		keyPath := EscapePathSegment(k)
+		if path != "" {
+			keyPath = path + "." + keyPath
+		}
+		old, exists := dst[k]
+		if !exists {
+			dst[k] = v
+			changed++
+			continue
+		}
+		oldMap, oldIsMap := old.(map[string]interface{})
+		newMap, newIsMap := v.(map[string]interface{})
+		switch {
+		case oldIsMap && newIsMap:
+			n, err := mergeObjects(oldMap, newMap, keyPath, opts)
+			if err != nil {
+				return 0, err
+			}
+			changed += n
+		case oldIsMap || newIsMap:
+			return 0, WithCode(CodeTypeConflict, keyPath, fmt.Errorf("cannot merge '%s': existing value is %s but the new value is %s", keyPath, JSONKind(old), JSONKind(v)))
+		case !opts.unchanged(old, v):
+			if opts.PreserveTypes {
+				if err := CheckSameType(keyPath, old, v); err != nil {
+					return 0, err
+				}
+			}
+			opts.logf("Overwriting key '%s'\n", keyPath)
+			dst[k] = v
+			changed++
+		}
+	}
+	return changed, nil
+}
+
+// JSONKind names the JSON type of a decoded value for error messages
+func JSONKind(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case bool:
+		return "a boolean"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// ParseJSONInput parses JSON input and preserves the original structure.
+// Objects, arrays, strings etc. are kept in their native types.
+// With strictKeys, keys containing '.' are rejected since they cannot be addressed with dot-notation paths.
+func ParseJSONInput(jsonData string, strictKeys bool) (map[string]interface{}, error) {
+	// Validate JSON syntax and unmarshal into map[string]interface{}
+	if strings.TrimSpace(jsonData) == "" {
+		return nil, WithCode(CodeInvalidJSON, "", fmt.Errorf("JSON data is empty"))
+	}
+
+	var rawData map[string]interface{}
+	if err := json.Unmarshal([]byte(jsonData), &rawData); err != nil {
+		return nil, WithCode(CodeInvalidJSON, "", fmt.Errorf("invalid JSON data: %w", err))
+	}
+
+	if len(rawData) == 0 {
+		return nil, WithCode(CodeInvalidJSON, "", fmt.Errorf("JSON data is empty"))
+	}
+
+	if strictKeys {
+		if err := checkDottedKeys(rawData, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	// Return the data as-is (no conversion to strings)
+	return rawData, nil
+}
+
+// StringifyValues returns the top-level keys of the JSON object jsonData with every value stored
+// as a string, the shape earlier versions wrote: strings are kept and any other value becomes its
+// compact JSON text (5 -> "5", true -> "true", {"a": 1} -> "{\"a\":1}"). Numbers keep their input digits
+func StringifyValues(jsonData string) (map[string]interface{}, error) {
+	parsed := gjson.Parse(jsonData)
+	if !parsed.IsObject() {
+		return nil, WithCode(CodeInvalidJSON, "", fmt.Errorf("JSON data must be an object"))
+	}
+	data := map[string]interface{}{}
+	var err error
+	parsed.ForEach(func(key, value gjson.Result) bool {
+		if value.Type == gjson.String {
+			data[key.String()] = value.String()
+			return true
+		}
+		var buf bytes.Buffer
+		if err = json.Compact(&buf, []byte(value.Raw)); err != nil {
+			err = WithCode(CodeInvalidJSON, key.String(), fmt.Errorf("invalid JSON value for '%s': %w", key.String(), err))
+			return false
+		}
+		data[key.String()] = buf.String()
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// checkDottedKeys returns an error for the first key (at any depth) containing '.',
+// because --json_path splits on '.' and such a key could never be addressed
+func checkDottedKeys(data map[string]interface{}, prefix string) error {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+		if strings.Contains(k, ".") {
+			return WithCode(CodeUsage, path, fmt.Errorf("key '%s' contains '.' and can only be addressed in --json_path by escaping it as '\\.' (disable --strict-keys to allow it)", path))
+		}
+		if nested, ok := data[k].(map[string]interface{}); ok {
+			if err := checkDottedKeys(nested, path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Packing strategies for ChunkDataIntoSecrets
+const (
+	// PackStrategyAlpha fills parts greedily in alphabetical key order (or ChunkOptions.Order)
+	PackStrategyAlpha = "alpha"
+	// PackStrategyCompact uses first-fit-decreasing by serialized key size to minimize the number of parts
+	PackStrategyCompact = "compact"
+)
+
+// Key orderings used when sorting keys for chunking
+const (
+	SortCaseSensitive   = "case-sensitive"
+	SortCaseInsensitive = "case-insensitive"
+)
+
+// ChunkOptions controls how ChunkDataIntoSecrets packs keys into parts
+type ChunkOptions struct {
+	// MaxSize is the maximum serialized size of a single part in bytes
+	MaxSize int
+	// Strategy is PackStrategyAlpha or PackStrategyCompact
+	Strategy string
+	// KeyOrder is SortCaseSensitive or SortCaseInsensitive
+	KeyOrder string
+	// Compact measures parts without indentation, matching how --compact parts are stored
+	Compact bool
+	// Order, when set, replaces the alphabetical key order with the recorded insertion order
+	Order *InsertionOrder
+	// Pins maps top-level keys to the part they must be placed in, by position in part order
+	// (0 = base); the other keys are packed around them
+	Pins map[string]int
+}
+
+// Marshal serializes v with MarshalSecret, exactly as the part will be written
+func (o ChunkOptions) Marshal(v interface{}) ([]byte, error) {
+	if o.Order != nil {
+		return o.Order.Marshal(v, o.Compact, o.KeyOrder)
+	}
+	return MarshalSecret(v, o.Compact)
+}
+
+// KeyLess reports whether key a sorts before key b in the given order.
+// Case-insensitive ordering falls back to a case-sensitive comparison so it stays deterministic.
+func KeyLess(a, b string, order string) bool {
+	if order == SortCaseInsensitive {
+		la, lb := strings.ToLower(a), strings.ToLower(b)
+		if la != lb {
+			return la < lb
+		}
+	}
+	return a < b
+}
+
+// ChunkDataIntoSecrets splits data into chunks whose serialized size stays within opts.MaxSize bytes
+// opts.Strategy selects how keys are packed; all strategies are deterministic
+func ChunkDataIntoSecrets(data map[string]interface{}, opts ChunkOptions) ([]map[string]interface{}, error) {
+	maxSize := opts.MaxSize
+	if opts.Strategy == PackStrategyCompact {
+		return chunkDataCompact(data, opts)
+	}
+
+	// Extract and sort keys to ensure deterministic chunking
+	var keys []string
+	if opts.Order != nil {
+		keys = opts.Order.Keys(data, "", opts.KeyOrder)
+	} else {
+		keys = make([]string, 0, len(data))
+		for k := range data {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return KeyLess(keys[i], keys[j], opts.KeyOrder) })
+	}
+
+	chunks, pinned, err := pinnedChunks(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	index := 0
+	for _, k := range keys {
+		if pinned[k] {
+			continue
+		}
+		v := data[k]
+		// Check if this key-value pair alone exceeds the chunk size
+		testSingle := map[string]interface{}{k: v}
+		jsSingle, err := opts.Marshal(testSingle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal key '%s': %w", k, err)
+		}
+		if SecretSize(string(jsSingle)) > maxSize {
+			return nil, WithCode(CodeSizeExceeded, k, fmt.Errorf("key '%s' exceeds max chunk size (%d bytes): got %d. This data cannot be stored in secrets manager even as an individual secret as this hits the max limit supported by AWS", k, maxSize, SecretSize(string(jsSingle))))
+		}
+		for {
+			if index == len(chunks) {
+				chunks = append(chunks, map[string]interface{}{})
+			}
+			current := chunks[index]
+			if len(current) == 0 {
+				current[k] = v
+				break
+			}
+			// Trial-based size check: test if adding new key would exceed limit
+			test := make(map[string]interface{}) // Create empty temporary map
+			for ck, cv := range current {        // Copy existing chunk into test
+				test[ck] = cv
+			}
+			test[k] = v                   // Add the new key-value to test (trial add)
+			js, err := opts.Marshal(test) // Convert test map to JSON to measure size
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal chunk with key '%s': %w", k, err)
+			}
+			if SecretSize(string(js)) <= maxSize {
+				// Test fits → actually add the key to current chunk
+				current[k] = v
+				break
+			}
+			// Test exceeded limit → move on to the next chunk, which may already hold pinned keys
+			index++
+		}
+	}
+	if err := checkPinnedGaps(chunks); err != nil {
+		return nil, err
+	}
+	return chunks, nil
+}
+
+// pinnedChunks returns chunks holding only the keys of opts.Pins, each in its pinned chunk,
+// and the set of pinned keys. Every pinned key must exist and the keys pinned to a part must fit in it
+func pinnedChunks(data map[string]interface{}, opts ChunkOptions) ([]map[string]interface{}, map[string]bool, error) {
+	keys := make([]string, 0, len(opts.Pins))
+	for k := range opts.Pins {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	chunks := []map[string]interface{}{}
+	pinned := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		v, exists := data[k]
+		if !exists {
+			return nil, nil, WithCode(CodeKeyNotFound, k, fmt.Errorf("pinned key '%s' does not exist", k))
+		}
+		part := opts.Pins[k]
+		for len(chunks) <= part {
+			chunks = append(chunks, map[string]interface{}{})
+		}
+		chunks[part][k] = v
+		pinned[k] = true
+	}
+	for i, chunk := range chunks {
+		if len(chunk) == 0 {
+			continue
+		}
+		js, err := opts.Marshal(chunk)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to marshal the keys pinned to part %d: %w", i, err)
+		}
+		if size := SecretSize(string(js)); size > opts.MaxSize {
+			return nil, nil, WithCode(CodeSizeExceeded, "", fmt.Errorf("the keys pinned to part %d need %d bytes, which exceeds the max chunk size (%d bytes)", i, size, opts.MaxSize))
+		}
+	}
+	return chunks, pinned, nil
+}
+
+// checkPinnedGaps rejects chunks left empty below a pinned part, which would be written as "{}"
+func checkPinnedGaps(chunks []map[string]interface{}) error {
+	for i, chunk := range chunks {
+		if len(chunk) == 0 {
+			return WithCode(CodeEmptyParts, "", fmt.Errorf("part %d would be empty because the unpinned keys fit in fewer parts than the highest pinned part; pin to a lower part", i))
+		}
+	}
+	return nil
+}
+
+// chunkDataCompact packs keys first-fit-decreasing by their serialized size.
+// Ties are broken by key order so the output is deterministic.
+func chunkDataCompact(data map[string]interface{}, opts ChunkOptions) ([]map[string]interface{}, error) {
+	maxSize := opts.MaxSize
+	keys := make([]string, 0, len(data))
+	sizes := make(map[string]int, len(data))
+	for k, v := range data {
+		jsSingle, err := opts.Marshal(map[string]interface{}{k: v})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal key '%s': %w", k, err)
+		}
+		size := SecretSize(string(jsSingle))
+		if size > maxSize {
+			return nil, WithCode(CodeSizeExceeded, k, fmt.Errorf("key '%s' exceeds max chunk size (%d bytes): got %d. This data cannot be stored in secrets manager even as an individual secret as this hits the max limit supported by AWS", k, maxSize, size))
+		}
+		keys = append(keys, k)
+		sizes[k] = size
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if sizes[keys[i]] != sizes[keys[j]] {
+			return sizes[keys[i]] > sizes[keys[j]]
+		}
+		return KeyLess(keys[i], keys[j], opts.KeyOrder)
+	})
+
+	chunks, pinned, err := pinnedChunks(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		if pinned[k] {
+			continue
+		}
+		placed := false
+		// Trial-add the key to each existing chunk and keep it in the first one that fits
+		for _, chunk := range chunks {
+			chunk[k] = data[k]
+			js, err := opts.Marshal(chunk)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal chunk with key '%s': %w", k, err)
+			}
+			if SecretSize(string(js)) <= maxSize {
+				placed = true
+				break
+			}
+			delete(chunk, k)
+		}
+		if !placed {
+			chunks = append(chunks, map[string]interface{}{k: data[k]})
+		}
+	}
+	if err := checkPinnedGaps(chunks); err != nil {
+		return nil, err
+	}
+	return chunks, nil
+}
+
+// CheckChunks verifies that the top-level keys of chunks are exactly the keys of data, each in one chunk
+// It guards every write against a packing bug silently dropping or duplicating a key
+func CheckChunks(data map[string]interface{}, chunks []map[string]interface{}) error {
+	seen := make(map[string]int, len(data))
+	var duplicated, unexpected, dropped []string
+	for i, chunk := range chunks {
+		for k := range chunk {
+			if first, ok := seen[k]; ok {
+				duplicated = append(duplicated, fmt.Sprintf("%s (parts %d and %d)", k, first, i))
+				continue
+			}
+			seen[k] = i
+			if _, ok := data[k]; !ok {
+				unexpected = append(unexpected, k)
+			}
+		}
+	}
+	for k := range data {
+		if _, ok := seen[k]; !ok {
+			dropped = append(dropped, k)
+		}
+	}
+	var problems []string
+	for _, p := range []struct {
+		label string
+		keys  []string
+	}{{"dropped", dropped}, {"duplicated", duplicated}, {"not in the input", unexpected}} {
+		if len(p.keys) > 0 {
+			sort.Strings(p.keys)
+			problems = append(problems, fmt.Sprintf("%s: %s", p.label, strings.Join(p.keys, ", ")))
+		}
+	}
+	if len(problems) > 0 {
+		return WithCode(CodeInternal, "", fmt.Errorf("refusing to write: the planned parts do not hold every key exactly once (%s)", strings.Join(problems, "; ")))
+	}
+	return nil
+}
+
+// SplitJSONPath splits a dot-notation path into its key segments.
+// A literal dot inside a key is written as "\." and a literal backslash as "\\",
+// matching the escaping gjson uses for the find and get paths.
+func SplitJSONPath(jsonPath string) []string {
+	var parts []string
+	var current strings.Builder
+	for i := 0; i < len(jsonPath); i++ {
+		c := jsonPath[i]
+		switch {
+		case c == '\\' && i+1 < len(jsonPath):
+			i++
+			current.WriteByte(jsonPath[i])
+		case c == '.':
+			parts = append(parts, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(c)
+		}
+	}
+	return append(parts, current.String())
+}
+
+// SplitDottedKeys routes the top-level keys of data that contain an unescaped '.' to nested objects:
+// "Db.Cred.User": v becomes {"User": v} added at "Db.Cred". Other keys stay at the root, with '\.'
+// and '\\' unescaped like in a --json_path. It returns the root keys and the nested additions by path
+// A key given in both forms, e.g. "Db" and "Db.Cred.User", is a conflict since the order of the
+// two additions would decide the result
+func SplitDottedKeys(data map[string]interface{}) (map[string]interface{}, map[string]map[string]interface{}, error) {
+	root := map[string]interface{}{}
+	nested := map[string]map[string]interface{}{}
+	paths := make(map[string]string, len(data))
+	for k, v := range data {
+		segments := SplitJSONPath(k)
+		for _, segment := range segments {
+			if segment == "" {
+				return nil, nil, WithCode(CodeInvalidJSON, k, fmt.Errorf("input key '%s' has an empty path segment", k))
+			}
+		}
+		full := strings.Join(escapeSegments(segments), ".")
+		if other, ok := paths[full]; ok {
+			return nil, nil, WithCode(CodeDuplicateKey, full, fmt.Errorf("input keys '%s' and '%s' both address '%s'", other, k, full))
+		}
+		paths[full] = k
+		if len(segments) == 1 {
+			root[segments[0]] = v
+			continue
+		}
+		parent := strings.Join(escapeSegments(segments[:len(segments)-1]), ".")
+		if nested[parent] == nil {
+			nested[parent] = map[string]interface{}{}
+		}
+		nested[parent][segments[len(segments)-1]] = v
+	}
+	for full, k := range paths {
+		segments := SplitJSONPath(full)
+		for i := 1; i < len(segments); i++ {
+			prefix := strings.Join(escapeSegments(segments[:i]), ".")
+			if other, ok := paths[prefix]; ok {
+				return nil, nil, WithCode(CodeDuplicateKey, full, fmt.Errorf("input key '%s' adds under '%s', which input key '%s' sets as a whole; give only one of them", k, prefix, other))
+			}
+		}
+	}
+	return root, nested, nil
+}
+
+// EscapePathSegment escapes a key so it can be used as a single segment of a dot-notation path
+func EscapePathSegment(key string) string {
+	key = strings.ReplaceAll(key, "\\", "\\\\")
+	return strings.ReplaceAll(key, ".", "\\.")
+}
+
+// ObjectAtPath resolves segments, the leading segments of the dot-notation jsonPath, in all and
+// returns the object they name: all of jsonPath's segments for the object itself, all but the
+// last for the parent of a leaf. A missing or non-object segment fails with one error naming
+// jsonPath and how far it resolved
+func ObjectAtPath(all map[string]interface{}, jsonPath string, segments []string) (map[string]interface{}, error) {
+	current := all
+	for i, key := range segments {
+		resolved := strings.Join(escapeSegments(segments[:i+1]), ".")
+		val, exists := current[key]
+		if !exists {
+			return nil, WithCode(CodeKeyNotFound, jsonPath, fmt.Errorf("'%s' of path '%s' does not exist", resolved, jsonPath))
+		}
+		nested, ok := val.(map[string]interface{})
+		if !ok {
+			return nil, WithCode(CodeNotObject, jsonPath, fmt.Errorf("'%s' of path '%s' is %s, not an object", resolved, jsonPath, JSONKind(val)))
+		}
+		current = nested
+	}
+	return current, nil
+}
+
+// escapeSegments escapes every key of segments for joining into a dot-notation path
+func escapeSegments(segments []string) []string {
+	escaped := make([]string, len(segments))
+	for i, segment := range segments {
+		escaped[i] = EscapePathSegment(segment)
+	}
+	return escaped
+}
+
+// AddSecretToGivenPath merges new into the nested object at jsonPath and returns the number of keys that changed
+func AddSecretToGivenPath(all map[string]interface{}, new map[string]interface{}, jsonPath string, opts AddOptions) (int, error) {
+	current, err := ObjectAtPath(all, jsonPath, SplitJSONPath(jsonPath))
+	if err != nil {
+		return 0, fmt.Errorf("--json_path must name an existing object to add keys to: %w", err)
+	}
+
+	if opts.Merge {
+		return mergeObjects(current, new, jsonPath, opts)
+	}
+
+	// Merge new data into the target map
+	changed := 0
+	for k, v := range new {
+		old, exists := current[k]
+		if opts.SkipExisting && exists && opts.unchanged(old, v) {
+			continue
+		}
+		if opts.ForceUpdate {
+			if !exists {
+				return 0, WithCode(CodeKeyNotFound, k, fmt.Errorf("key '%s' not found at path '%s' for update", k, jsonPath))
+			}
+			if opts.PreserveTypes {
+				if err := CheckSameType(jsonPath+"."+EscapePathSegment(k), old, v); err != nil {
+					return 0, err
+				}
+			}
+			opts.logf("Overwriting key '%s' at path '%s'\n", k, jsonPath)
+		} else {
+			if exists {
+				return 0, WithCode(CodeKeyExists, k, fmt.Errorf("key '%s' already exists at path '%s'", k, jsonPath))
+			}
+		}
+		current[k] = v
+		changed++
+	}
+	return changed, nil
+}
@@ -0,0 +1,225 @@
+package multipart
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPackStrategies(t *testing.T) {
+	sized := func(sizes map[string]int) map[string]interface{} {
+		data := make(map[string]interface{}, len(sizes))
+		for k, n := range sizes {
+			data[k] = strings.Repeat("x", n)
+		}
+		return data
+	}
+	tests := []struct {
+		name    string
+		data    map[string]interface{}
+		alpha   int
+		compact int
+	}{
+		{
+			name:    "mixed sizes need fewer parts when packed largest first",
+			data:    sized(map[string]int{"a": 70, "b": 40, "c": 10, "d": 70, "e": 40}),
+			alpha:   4,
+			compact: 3,
+		},
+		{
+			name:    "small values fit one part either way",
+			data:    sized(map[string]int{"a": 10, "b": 10, "c": 10}),
+			alpha:   1,
+			compact: 1,
+		},
+		{
+			name:    "one value per part either way",
+			data:    sized(map[string]int{"a": 70, "b": 70}),
+			alpha:   2,
+			compact: 2,
+		},
+	}
+	const maxSize = 120
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for strategy, want := range map[string]int{PackStrategyAlpha: tt.alpha, PackStrategyCompact: tt.compact} {
+				chunks, err := ChunkDataIntoSecrets(tt.data, ChunkOptions{MaxSize: maxSize, Strategy: strategy})
+				if err != nil {
+					t.Fatalf("%s: %v", strategy, err)
+				}
+				if len(chunks) != want {
+					t.Errorf("%s: %d parts, want %d", strategy, len(chunks), want)
+				}
+				seen := map[string]bool{}
+				for i, chunk := range chunks {
+					for k := range chunk {
+						if seen[k] {
+							t.Errorf("%s: key '%s' is in more than one part", strategy, k)
+						}
+						seen[k] = true
+					}
+					js, err := json.MarshalIndent(chunk, "", "  ")
+					if err != nil {
+						t.Fatal(err)
+					}
+					if size := SecretSize(string(js)); size > maxSize {
+						t.Errorf("%s: part %d is %d bytes, over %d", strategy, i, size, maxSize)
+					}
+				}
+				if len(seen) != len(tt.data) {
+					t.Errorf("%s: parts hold %d keys, want %d", strategy, len(seen), len(tt.data))
+				}
+			}
+		})
+	}
+}
+
+func TestSplitJSONPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{path: "a", want: []string{"a"}},
+		{path: "a.b.c", want: []string{"a", "b", "c"}},
+		{path: `a\.b.c`, want: []string{"a.b", "c"}},
+		{path: `a\\.b`, want: []string{`a\`, "b"}},
+		{path: `x.y\.z`, want: []string{"x", "y.z"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got := SplitJSONPath(tt.path)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("SplitJSONPath(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+			escaped := make([]string, len(got))
+			for i, segment := range got {
+				escaped[i] = EscapePathSegment(segment)
+			}
+			if joined := strings.Join(escaped, "."); joined != tt.path {
+				t.Errorf("escaped segments join to %q, want %q", joined, tt.path)
+			}
+		})
+	}
+}
+
+func TestAddSecretToGivenPathDottedKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		new     map[string]interface{}
+		want    string
+		wantErr bool
+	}{
+		{name: "escaped dot names one key", path: `db\.prod`, new: map[string]interface{}{"user": "u"}, want: `{"db":{"pool.size":{},"prod":{}},"db.prod":{"user":"u"}}`},
+		{name: "escaped dot in a nested segment", path: `db.pool\.size`, new: map[string]interface{}{"max": 5}, want: `{"db":{"pool.size":{"max":5},"prod":{}},"db.prod":{}}`},
+		{name: "added keys may hold dots", path: "db", new: map[string]interface{}{"a.b": "v"}, want: `{"db":{"a.b":"v","pool.size":{},"prod":{}},"db.prod":{}}`},
+		{name: "unescaped dot is a nested lookup", path: "db.prod.missing", new: map[string]interface{}{"x": 1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			all := map[string]interface{}{
+				"db":      map[string]interface{}{"prod": map[string]interface{}{}, "pool.size": map[string]interface{}{}},
+				"db.prod": map[string]interface{}{},
+			}
+			_, err := AddSecretToGivenPath(all, tt.new, tt.path, AddOptions{})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			js, err := json.Marshal(all)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(js) != tt.want {
+				t.Errorf("data = %s, want %s", js, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		patch   string
+		want    string
+		set     int
+		removed []string
+	}{
+		{name: "null deletes a key", target: `{"a":"1","b":"2"}`, patch: `{"b":null}`, want: `{"a":"1"}`, removed: []string{"b"}},
+		{name: "null deletes a nested key", target: `{"db":{"user":"u","pass":"p"}}`, patch: `{"db":{"pass":null}}`, want: `{"db":{"user":"u"}}`, removed: []string{"db.pass"}},
+		{name: "null deletes a whole object", target: `{"a":"1","db":{"user":"u"}}`, patch: `{"db":null}`, want: `{"a":"1"}`, removed: []string{"db"}},
+		{name: "null for a missing key is a no-op", target: `{"a":"1"}`, patch: `{"nope":null}`, want: `{"a":"1"}`},
+		{name: "null deletes a dotted key", target: `{"a.b":"1","a":{"b":"2"}}`, patch: `{"a.b":null}`, want: `{"a":{"b":"2"}}`, removed: []string{`a\.b`}},
+		{name: "objects merge and scalars replace", target: `{"db":{"user":"u"},"n":1}`, patch: `{"db":{"pass":"p"},"n":2}`, want: `{"db":{"pass":"p","user":"u"},"n":2}`, set: 2},
+		{name: "arrays replace", target: `{"l":[1,2]}`, patch: `{"l":[3]}`, want: `{"l":[3]}`, set: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, err := ParseJSONInput(tt.target, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			patch, err := ParseJSONInput(tt.patch, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			set, removed := ApplyMergePatch(target, patch)
+			js, err := MarshalSecret(target, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(js) != tt.want {
+				t.Errorf("result = %s, want %s", js, tt.want)
+			}
+			if set != tt.set {
+				t.Errorf("set = %d, want %d", set, tt.set)
+			}
+			if !slices.Equal(removed, tt.removed) {
+				t.Errorf("removed = %q, want %q", removed, tt.removed)
+			}
+		})
+	}
+}
+
+func TestObjectAtPath(t *testing.T) {
+	all := map[string]interface{}{"db": map[string]interface{}{"user": "u", "pool": map[string]interface{}{"max": 5}}}
+	tests := []struct {
+		path string
+		// parent resolves all but the last segment
+		parent bool
+		code   string
+		err    string
+	}{
+		{path: "db.pool"},
+		{path: "db.pool.max", parent: true},
+		{path: "db.missing.max", parent: true, code: CodeKeyNotFound, err: "'db.missing' of path 'db.missing.max' does not exist"},
+		{path: "db.user.name", parent: true, code: CodeNotObject, err: "'db.user' of path 'db.user.name' is a string, not an object"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			segments := SplitJSONPath(tt.path)
+			if tt.parent {
+				segments = segments[:len(segments)-1]
+			}
+			got, err := ObjectAtPath(all, tt.path, segments)
+			if tt.err == "" {
+				if err != nil || got["max"] != 5 {
+					t.Fatalf("ObjectAtPath = %v, %v, want the pool object", got, err)
+				}
+				return
+			}
+			var coded *CodedError
+			if !errors.As(err, &coded) || coded.Code != tt.code || coded.Key != tt.path || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("error = %v, want %s %q for %s", err, tt.code, tt.err, tt.path)
+			}
+		})
+	}
+}
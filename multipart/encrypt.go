@@ -0,0 +1,168 @@
+package multipart
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// EncryptedPrefix marks a string value as encrypted by EncryptValue
+// The rest of the string is the base64 encoded AES-GCM nonce followed by the sealed JSON of the value
+const EncryptedPrefix = "enc:aes-gcm:"
+
+// IsEncrypted reports whether v is a value produced by EncryptValue
+func IsEncrypted(v interface{}) bool {
+	s, ok := v.(string)
+	return ok && strings.HasPrefix(s, EncryptedPrefix)
+}
+
+// newGCM builds the AES-GCM cipher for key, which must be 16, 24 or 32 bytes long
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptValue seals the JSON encoding of v with key, so values of any JSON type round-trip
+func EncryptValue(v interface{}, key []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, nil)
+	return EncryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptValue opens a value produced by EncryptValue and returns the original decoded value
+func DecryptValue(s string, key []byte) (interface{}, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, EncryptedPrefix))
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("malformed encrypted value")
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("wrong encryption key or corrupted value")
+	}
+	var v interface{}
+	if err := json.Unmarshal(plaintext, &v); err != nil {
+		return nil, fmt.Errorf("decrypted value is not valid JSON: %w", err)
+	}
+	return v, nil
+}
+
+// EncryptPaths encrypts the value at every dot-notation path in paths and returns how many were encrypted
+// Values that are already encrypted are left unchanged; a missing path is an error
+func EncryptPaths(data map[string]interface{}, paths []string, key []byte) (int, error) {
+	encrypted := 0
+	for _, path := range paths {
+		segments := SplitJSONPath(path)
+		current := data
+		for _, segment := range segments[:len(segments)-1] {
+			nested, ok := current[segment].(map[string]interface{})
+			if !ok {
+				return encrypted, WithCode(CodeKeyNotFound, path, fmt.Errorf("key '%s' to encrypt does not exist", path))
+			}
+			current = nested
+		}
+		leaf := segments[len(segments)-1]
+		value, exists := current[leaf]
+		if !exists {
+			return encrypted, WithCode(CodeKeyNotFound, path, fmt.Errorf("key '%s' to encrypt does not exist", path))
+		}
+		if IsEncrypted(value) {
+			continue
+		}
+		sealed, err := EncryptValue(value, key)
+		if err != nil {
+			return encrypted, WithCode(CodeEncryption, path, fmt.Errorf("failed to encrypt key '%s': %w", path, err))
+		}
+		current[leaf] = sealed
+		encrypted++
+	}
+	return encrypted, nil
+}
+
+// EncryptedPaths returns the sorted dot-notation paths of the encrypted values in data, at any depth
+// With key set each of them must decrypt with it, so one set never holds values under two keys
+func EncryptedPaths(data map[string]interface{}, key []byte) ([]string, error) {
+	var paths []string
+	if err := collectEncrypted(data, "", key, &paths); err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func collectEncrypted(data map[string]interface{}, prefix string, key []byte, paths *[]string) error {
+	for k, v := range data {
+		path := EscapePathSegment(k)
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+		if nested, ok := v.(map[string]interface{}); ok {
+			if err := collectEncrypted(nested, path, key, paths); err != nil {
+				return err
+			}
+			continue
+		}
+		if !IsEncrypted(v) {
+			continue
+		}
+		if key != nil {
+			if _, err := DecryptValue(v.(string), key); err != nil {
+				return WithCode(CodeEncryption, path, fmt.Errorf("stored key '%s' cannot be decrypted with --encryption-key-file: %w", path, err))
+			}
+		}
+		*paths = append(*paths, path)
+	}
+	return nil
+}
+
+// DecryptAll replaces every encrypted value in data, at any depth, with its decrypted value
+func DecryptAll(data map[string]interface{}, key []byte) error {
+	return decryptObject(data, "", key)
+}
+
+func decryptObject(data map[string]interface{}, prefix string, key []byte) error {
+	for k, v := range data {
+		path := EscapePathSegment(k)
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+		if nested, ok := v.(map[string]interface{}); ok {
+			if err := decryptObject(nested, path, key); err != nil {
+				return err
+			}
+			continue
+		}
+		if !IsEncrypted(v) {
+			continue
+		}
+		plain, err := DecryptValue(v.(string), key)
+		if err != nil {
+			return WithCode(CodeEncryption, path, fmt.Errorf("failed to decrypt key '%s': %w", path, err))
+		}
+		data[k] = plain
+	}
+	return nil
+}
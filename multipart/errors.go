@@ -0,0 +1,46 @@
+package multipart
+
+// Stable error codes attached to errors with WithCode
+const (
+	CodeUsage                  = "USAGE"
+	CodeInvalidJSON            = "INVALID_JSON"
+	CodeKeyExists              = "KEY_EXISTS"
+	CodeTargetExists           = "TARGET_EXISTS"
+	CodeKeyNotFound            = "KEY_NOT_FOUND"
+	CodeNotObject              = "NOT_OBJECT"
+	CodeNotArray               = "NOT_ARRAY"
+	CodeTypeConflict           = "TYPE_CONFLICT"
+	CodeDuplicateKey           = "DUPLICATE_KEY"
+	CodeSizeExceeded           = "SIZE_EXCEEDED"
+	CodeTooManyParts           = "TOO_MANY_PARTS"
+	CodeTooManyKeys            = "TOO_MANY_KEYS"
+	CodeEmptyParts             = "EMPTY_PARTS"
+	CodeSchemaViolation        = "SCHEMA_VIOLATION"
+	CodeEncryption             = "ENCRYPTION_FAILED"
+	CodeSecretNotFound         = "SECRET_NOT_FOUND"
+	CodeConcurrentModification = "CONCURRENT_MODIFICATION"
+	CodeTimeout                = "TIMEOUT"
+	CodeInterrupted            = "INTERRUPTED"
+	CodeAWS                    = "AWS_ERROR"
+	CodeInternal               = "ERROR"
+)
+
+// CodedError attaches a stable code, and the key it concerns if any, to an error
+type CodedError struct {
+	Code string
+	Key  string
+	Err  error
+}
+
+func (e *CodedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CodedError) Unwrap() error {
+	return e.Err
+}
+
+// WithCode wraps err with code and the offending key ("" if none)
+func WithCode(code, key string, err error) error {
+	return &CodedError{Code: code, Key: key, Err: err}
+}
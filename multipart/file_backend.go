@@ -0,0 +1,243 @@
+package multipart
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+// FileClient is a SecretsManagerClient backed by a directory of <secret name>.json files
+// holding each SecretString verbatim. It is meant for tests and offline development:
+// the VersionId is a hash of the content, only AWSCURRENT exists, tags are accepted but
+// not stored, and deleted secrets are removed immediately
+type FileClient struct {
+	dir string
+}
+
+// NewFileClient returns a FileClient rooted at dir, which must already exist
+func NewFileClient(dir string) (*FileClient, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("secrets directory '%s': %w", dir, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("secrets directory '%s' is not a directory", dir)
+	}
+	return &FileClient{dir: dir}, nil
+}
+
+// path returns the file holding name; each '/' in the name is a directory level
+// Empty, "." and ".." segments are refused, so a name can neither alias another one nor leave dir
+func (c *FileClient) path(name string) (string, error) {
+	rel := filepath.FromSlash(name) + ".json"
+	segments := strings.Split(name, "/")
+	if slices.Contains(segments, "") || slices.Contains(segments, ".") || slices.Contains(segments, "..") || !filepath.IsLocal(rel) {
+		return "", &types.InvalidParameterException{Message: aws.String(fmt.Sprintf("the file backend cannot store secret '%s': names must not contain empty, '.' or '..' segments", name))}
+	}
+	return filepath.Join(c.dir, rel), nil
+}
+
+// read returns the SecretString of name, or a ResourceNotFoundException if it does not exist
+func (c *FileClient) read(name string) (string, error) {
+	path, err := c.path(name)
+	if err != nil {
+		return "", err
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", &types.ResourceNotFoundException{Message: aws.String(fmt.Sprintf("Secrets Manager can't find the specified secret '%s'", name))}
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// write replaces the content of name atomically and returns its new VersionId
+func (c *FileClient) write(name, value string) (string, error) {
+	path, err := c.path(name)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(value); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	return fileVersionID(value), nil
+}
+
+// fileVersionID derives a stable VersionId from the content so concurrency checks still work
+func fileVersionID(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:16])
+}
+
+func (c *FileClient) ListSecrets(ctx context.Context, params *secretsmanager.ListSecretsInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.ListSecretsOutput, error) {
+	var prefixes []string
+	for _, filter := range params.Filters {
+		if filter.Key == types.FilterNameStringTypeName {
+			prefixes = append(prefixes, filter.Values...)
+		}
+	}
+	out := &secretsmanager.ListSecretsOutput{}
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".json") || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(c.dir, path)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(filepath.ToSlash(rel), ".json")
+		if !hasAnyPrefix(name, prefixes) {
+			return nil
+		}
+		out.SecretList = append(out.SecretList, types.SecretListEntry{Name: aws.String(name)})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// hasAnyPrefix reports whether name starts with one of prefixes; no prefixes match everything
+func hasAnyPrefix(name string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *FileClient) GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	name := aws.ToString(params.SecretId)
+	if stage := aws.ToString(params.VersionStage); stage != "" && stage != StageCurrent {
+		return nil, &types.ResourceNotFoundException{Message: aws.String(fmt.Sprintf("the file backend only stores %s, not %s", StageCurrent, stage))}
+	}
+	value, err := c.read(name)
+	if err != nil {
+		return nil, err
+	}
+	return &secretsmanager.GetSecretValueOutput{Name: aws.String(name), SecretString: aws.String(value), VersionId: aws.String(fileVersionID(value))}, nil
+}
+
+func (c *FileClient) BatchGetSecretValue(ctx context.Context, params *secretsmanager.BatchGetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.BatchGetSecretValueOutput, error) {
+	out := &secretsmanager.BatchGetSecretValueOutput{}
+	for _, name := range params.SecretIdList {
+		value, err := c.read(name)
+		var notFound *types.ResourceNotFoundException
+		if errors.As(err, &notFound) {
+			out.Errors = append(out.Errors, types.APIErrorType{SecretId: aws.String(name), ErrorCode: aws.String("ResourceNotFoundException"), Message: notFound.Message})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		out.SecretValues = append(out.SecretValues, types.SecretValueEntry{Name: aws.String(name), SecretString: aws.String(value), VersionId: aws.String(fileVersionID(value))})
+	}
+	return out, nil
+}
+
+func (c *FileClient) DescribeSecret(ctx context.Context, params *secretsmanager.DescribeSecretInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.DescribeSecretOutput, error) {
+	name := aws.ToString(params.SecretId)
+	value, err := c.read(name)
+	if err != nil {
+		return nil, err
+	}
+	return &secretsmanager.DescribeSecretOutput{
+		Name:               aws.String(name),
+		VersionIdsToStages: map[string][]string{fileVersionID(value): {StageCurrent}},
+	}, nil
+}
+
+func (c *FileClient) CreateSecret(ctx context.Context, params *secretsmanager.CreateSecretInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.CreateSecretOutput, error) {
+	name := aws.ToString(params.Name)
+	path, err := c.path(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil, &types.ResourceExistsException{Message: aws.String(fmt.Sprintf("the secret '%s' already exists", name))}
+	}
+	version, err := c.write(name, aws.ToString(params.SecretString))
+	if err != nil {
+		return nil, err
+	}
+	return &secretsmanager.CreateSecretOutput{Name: aws.String(name), VersionId: aws.String(version)}, nil
+}
+
+func (c *FileClient) UpdateSecret(ctx context.Context, params *secretsmanager.UpdateSecretInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.UpdateSecretOutput, error) {
+	name := aws.ToString(params.SecretId)
+	if _, err := c.read(name); err != nil {
+		return nil, err
+	}
+	version, err := c.write(name, aws.ToString(params.SecretString))
+	if err != nil {
+		return nil, err
+	}
+	return &secretsmanager.UpdateSecretOutput{Name: aws.String(name), VersionId: aws.String(version)}, nil
+}
+
+func (c *FileClient) DeleteSecret(ctx context.Context, params *secretsmanager.DeleteSecretInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.DeleteSecretOutput, error) {
+	name := aws.ToString(params.SecretId)
+	path, err := c.path(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, &types.ResourceNotFoundException{Message: aws.String(fmt.Sprintf("Secrets Manager can't find the specified secret '%s'", name))}
+		}
+		return nil, err
+	}
+	return &secretsmanager.DeleteSecretOutput{Name: aws.String(name)}, nil
+}
+
+func (c *FileClient) TagResource(ctx context.Context, params *secretsmanager.TagResourceInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.TagResourceOutput, error) {
+	return &secretsmanager.TagResourceOutput{}, nil
+}
+
+func (c *FileClient) UntagResource(ctx context.Context, params *secretsmanager.UntagResourceInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.UntagResourceOutput, error) {
+	return &secretsmanager.UntagResourceOutput{}, nil
+}
+
+func (c *FileClient) UpdateSecretVersionStage(ctx context.Context, params *secretsmanager.UpdateSecretVersionStageInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.UpdateSecretVersionStageOutput, error) {
+	return nil, &types.InvalidRequestException{Message: aws.String("staging labels are not supported by the file backend")}
+}
+
+func (c *FileClient) ReplicateSecretToRegions(ctx context.Context, params *secretsmanager.ReplicateSecretToRegionsInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.ReplicateSecretToRegionsOutput, error) {
+	return nil, &types.InvalidRequestException{Message: aws.String("replication is not supported by the file backend")}
+}
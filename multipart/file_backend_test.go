@@ -0,0 +1,72 @@
+package multipart
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+func TestFileClientNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{name: "app", valid: true},
+		{name: "team/app.prod", valid: true},
+		{name: "a/../../x"},
+		{name: "../x"},
+		{name: "a/./b"},
+		{name: "a//b"},
+		{name: ".."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			root := t.TempDir()
+			dir := filepath.Join(root, "secrets", "dir")
+			if err := os.MkdirAll(dir, 0o700); err != nil {
+				t.Fatal(err)
+			}
+			client, err := NewFileClient(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = client.CreateSecret(ctx, &secretsmanager.CreateSecretInput{Name: aws.String(tt.name), SecretString: aws.String(`{"a":"1"}`)})
+			if tt.valid {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(tt.name)}); err != nil {
+					t.Errorf("read back: %v", err)
+				}
+				return
+			}
+			var invalid *types.InvalidParameterException
+			if !errors.As(err, &invalid) {
+				t.Fatalf("CreateSecret error = %v, want an InvalidParameterException", err)
+			}
+			if _, err := client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(tt.name)}); !errors.As(err, &invalid) {
+				t.Errorf("GetSecretValue error = %v, want an InvalidParameterException", err)
+			}
+			if _, err := client.DeleteSecret(ctx, &secretsmanager.DeleteSecretInput{SecretId: aws.String(tt.name)}); !errors.As(err, &invalid) {
+				t.Errorf("DeleteSecret error = %v, want an InvalidParameterException", err)
+			}
+			// Nothing may be written anywhere under root, inside dir or not
+			err = filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+				if err == nil && !d.IsDir() {
+					t.Errorf("wrote %s", path)
+				}
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package multipart
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+// LockStage is the staging label marking the version of a lock secret that holds the lock
+// Moving a label names the version it is taken from, and Secrets Manager refuses the move when
+// the label is no longer there, so of two runs taking over the same lock only one succeeds
+const LockStage = "MULTIPART_LOCK"
+
+// lockReleaseTimeout bounds the release of a lock, which runs even after a deadline or an interrupt
+const lockReleaseTimeout = 10 * time.Second
+
+// LockName returns the name of the secret holding the lock of base, e.g. "app-lock"
+// It never parses as a part of base, so the lock secret is not read as data
+func (nm Naming) LockName(base string) string {
+	return base + nm.Separator + "lock"
+}
+
+// lockState is the SecretString of a lock secret version
+type lockState struct {
+	Owner     string    `json:"owner"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Lock is a held lock on a base, released with Release
+type Lock struct {
+	client  SecretsManagerClient
+	Name    string
+	version string
+	// ExpiresAt is when other runs may take the lock over, e.g. after this run crashed
+	ExpiresAt time.Time
+}
+
+// LockHeldError reports that another run holds the lock, or took it first
+type LockHeldError struct {
+	Name      string
+	Owner     string
+	ExpiresAt time.Time
+}
+
+func (e *LockHeldError) Error() string {
+	if e.Owner == "" {
+		return fmt.Sprintf("lock '%s' was taken by another run", e.Name)
+	}
+	return fmt.Sprintf("lock '%s' is held by %s until %s", e.Name, e.Owner, e.ExpiresAt.UTC().Format(time.RFC3339))
+}
+
+// TryLock makes one attempt to take the lock of base for owner, valid for ttl
+// The lock secret is created on first use. A held lock that has not expired fails with a
+// *LockHeldError; an expired one (left behind by a crashed run) is taken over
+func (sm *SecretManager) TryLock(ctx context.Context, base, owner string, ttl time.Duration) (*Lock, error) {
+	name := sm.Naming.LockName(base)
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("failed to generate lock token: %w", err)
+	}
+	state := lockState{Owner: owner, Token: hex.EncodeToString(token), ExpiresAt: time.Now().Add(ttl).UTC()}
+	js, err := json.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal lock state: %w", err)
+	}
+
+	var version, heldVersion string
+	desc, err := sm.client.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{SecretId: aws.String(name)})
+	var notFound *types.ResourceNotFoundException
+	switch {
+	case errors.As(err, &notFound):
+		resp, err := sm.client.CreateSecret(ctx, &secretsmanager.CreateSecretInput{
+			Name:         aws.String(name),
+			SecretString: aws.String(string(js)),
+			Description:  aws.String(fmt.Sprintf("Lock of the multipart secret '%s'", base)),
+		})
+		var exists *types.ResourceExistsException
+		if errors.As(err, &exists) {
+			return nil, &LockHeldError{Name: name}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to create lock secret '%s': %w", name, err)
+		}
+		version = aws.ToString(resp.VersionId)
+	case err != nil:
+		return nil, fmt.Errorf("failed to describe lock secret '%s': %w", name, err)
+	default:
+		if heldVersion = stageVersionID(desc, LockStage); heldVersion != "" {
+			resp, err := sm.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(name), VersionId: aws.String(heldVersion)})
+			if err != nil {
+				return nil, fmt.Errorf("failed to read lock secret '%s': %w", name, err)
+			}
+			var held lockState
+			if err := json.Unmarshal([]byte(aws.ToString(resp.SecretString)), &held); err != nil {
+				return nil, WithCode(CodeInvalidJSON, "", fmt.Errorf("failed to parse lock secret '%s': %w", name, err))
+			}
+			if time.Now().Before(held.ExpiresAt) {
+				return nil, &LockHeldError{Name: name, Owner: held.Owner, ExpiresAt: held.ExpiresAt}
+			}
+		}
+		resp, err := sm.client.UpdateSecret(ctx, &secretsmanager.UpdateSecretInput{SecretId: aws.String(name), SecretString: aws.String(string(js))})
+		if err != nil {
+			return nil, fmt.Errorf("failed to write lock secret '%s': %w", name, err)
+		}
+		version = aws.ToString(resp.VersionId)
+	}
+
+	input := &secretsmanager.UpdateSecretVersionStageInput{
+		SecretId:        aws.String(name),
+		VersionStage:    aws.String(LockStage),
+		MoveToVersionId: aws.String(version),
+	}
+	if heldVersion != "" {
+		input.RemoveFromVersionId = aws.String(heldVersion)
+	}
+	if _, err := sm.client.UpdateSecretVersionStage(ctx, input); err != nil {
+		var invalid *types.InvalidParameterException
+		if errors.As(err, &invalid) {
+			// The label moved since it was read: another run got there first
+			return nil, &LockHeldError{Name: name}
+		}
+		return nil, fmt.Errorf("failed to take lock '%s': %w", name, err)
+	}
+	return &Lock{client: sm.client, Name: name, version: version, ExpiresAt: state.ExpiresAt}, nil
+}
+
+// Release gives the lock up by detaching LockStage from the version it was taken with
+// It runs even when ctx is cancelled; a lock that expired and was taken over is not touched
+func (l *Lock) Release(ctx context.Context) error {
+	releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), lockReleaseTimeout)
+	defer cancel()
+	if _, err := l.client.UpdateSecretVersionStage(releaseCtx, &secretsmanager.UpdateSecretVersionStageInput{
+		SecretId:            aws.String(l.Name),
+		VersionStage:        aws.String(LockStage),
+		RemoveFromVersionId: aws.String(l.version),
+	}); err != nil {
+		return fmt.Errorf("failed to release lock '%s': %w", l.Name, err)
+	}
+	return nil
+}
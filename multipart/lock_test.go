@@ -0,0 +1,51 @@
+package multipart
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"secret-manager/multipart/multiparttest"
+)
+
+func TestTryLock(t *testing.T) {
+	ctx := context.Background()
+	client := multiparttest.NewClient()
+	sm := NewSecretManager(client, DefaultMaxParts)
+
+	first, err := sm.TryLock(ctx, "app", "first", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var held *LockHeldError
+	if _, err := sm.TryLock(ctx, "app", "second", time.Hour); !errors.As(err, &held) || held.Owner != "first" {
+		t.Fatalf("TryLock of a held lock = %v, want a *LockHeldError naming first", err)
+	}
+	if err := first.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// A lock left behind by a crashed run is taken over once it expired
+	stale, err := sm.TryLock(ctx, "app", "crashed", -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	taken, err := sm.TryLock(ctx, "app", "third", time.Hour)
+	if err != nil {
+		t.Fatalf("TryLock of an expired lock: %v", err)
+	}
+	// Releasing the stale lock must not free the lock of the run that took it over
+	if err := stale.Release(ctx); err == nil {
+		t.Error("Release of a lock that was taken over succeeded")
+	}
+	if _, err := sm.TryLock(ctx, "app", "fourth", time.Hour); !errors.As(err, &held) || held.Owner != "third" {
+		t.Fatalf("TryLock after the stale release = %v, want a *LockHeldError naming third", err)
+	}
+	if err := taken.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if names := client.Names(); len(names) != 1 || names[0] != "app-lock" {
+		t.Errorf("secrets = %v, want only the lock secret", names)
+	}
+}
@@ -0,0 +1,442 @@
+// Package multiparttest provides an in-memory SecretsManagerClient for tests of the multipart
+// package and of the command built on it
+package multiparttest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+const (
+	stageCurrent  = "AWSCURRENT"
+	stagePrevious = "AWSPREVIOUS"
+)
+
+// Client is an in-memory SecretsManagerClient. Versions and staging labels behave like they do
+// in AWS: a write adds a version that takes AWSCURRENT, the replaced one keeps AWSPREVIOUS, and a
+// ClientRequestToken is the VersionId, so a retried write with the same token is ignored
+// Deleted secrets are removed immediately. A Client is safe for concurrent use
+type Client struct {
+	mu      sync.Mutex
+	secrets map[string]*secret
+	calls   map[string]int
+	// ListPageSize and BatchPageSize, when positive, limit the entries of a ListSecrets or
+	// BatchGetSecretValue page so callers have to follow NextToken
+	ListPageSize  int
+	BatchPageSize int
+	// Intercept, when set, runs before every call with the operation and the secret it names
+	// ("" for ListSecrets and BatchGetSecretValue). An error it returns fails the call, which then
+	// has no effect and is not counted. The client is not locked while it runs, so it may change
+	// the stored secrets, e.g. to play a concurrent writer
+	Intercept func(op, name string) error
+}
+
+type secret struct {
+	versions map[string]string
+	stages   map[string]string
+	tags     map[string]string
+	regions  []string
+	next     int
+}
+
+// NewClient returns a Client holding no secrets
+func NewClient() *Client {
+	return &Client{secrets: map[string]*secret{}, calls: map[string]int{}}
+}
+
+// Put stores value as a new AWSCURRENT version of name, creating the secret if needed
+func (c *Client) Put(name, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.secrets[name]
+	if !ok {
+		s = &secret{versions: map[string]string{}, stages: map[string]string{}, tags: map[string]string{}}
+		c.secrets[name] = s
+	}
+	s.write(value, "")
+}
+
+// Value returns the AWSCURRENT SecretString of name
+func (c *Client) Value(name string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.secrets[name]
+	if !ok {
+		return "", false
+	}
+	return s.versions[s.stages[stageCurrent]], true
+}
+
+// Tags returns a copy of the tags of name
+func (c *Client) Tags(name string) map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tags := map[string]string{}
+	if s, ok := c.secrets[name]; ok {
+		for k, v := range s.tags {
+			tags[k] = v
+		}
+	}
+	return tags
+}
+
+// Regions returns the regions name is replicated to
+func (c *Client) Regions(name string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.secrets[name]; ok {
+		return append([]string(nil), s.regions...)
+	}
+	return nil
+}
+
+// Names returns the names of all secrets, sorted
+func (c *Client) Names() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sortedNames()
+}
+
+// Calls returns how often the operation named op (e.g. "UpdateSecret") was called
+func (c *Client) Calls(op string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls[op]
+}
+
+func (c *Client) sortedNames() []string {
+	names := make([]string, 0, len(c.secrets))
+	for name := range c.secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// write adds value as a new version that takes AWSCURRENT and returns its VersionId
+// token becomes the VersionId when set; a token already used by the secret changes nothing
+func (s *secret) write(value, token string) string {
+	if token != "" {
+		if _, exists := s.versions[token]; exists {
+			return token
+		}
+	}
+	id := token
+	if id == "" {
+		s.next++
+		id = "v" + strconv.Itoa(s.next)
+	}
+	s.versions[id] = value
+	if current, ok := s.stages[stageCurrent]; ok {
+		s.stages[stagePrevious] = current
+	}
+	s.stages[stageCurrent] = id
+	return id
+}
+
+// intercept runs c.Intercept, if set, for a call of op on name
+func (c *Client) intercept(op, name string) error {
+	if c.Intercept == nil {
+		return nil
+	}
+	return c.Intercept(op, name)
+}
+
+// lookup returns the secret called name, counting a call of op
+func (c *Client) lookup(op, name string) (*secret, error) {
+	c.calls[op]++
+	s, ok := c.secrets[name]
+	if !ok {
+		return nil, notFound(name)
+	}
+	return s, nil
+}
+
+func notFound(name string) error {
+	return &types.ResourceNotFoundException{Message: aws.String(fmt.Sprintf("Secrets Manager can't find the specified secret '%s'", name))}
+}
+
+// pageStart parses a NextToken produced by nextToken
+func pageStart(token *string) (int, error) {
+	if token == nil {
+		return 0, nil
+	}
+	start, err := strconv.Atoi(*token)
+	if err != nil {
+		return 0, &types.InvalidNextTokenException{Message: aws.String("invalid NextToken")}
+	}
+	return start, nil
+}
+
+// nextToken returns the token of the page after end, or nil when end is the last entry
+func nextToken(end, total int) *string {
+	if end >= total {
+		return nil
+	}
+	return aws.String(strconv.Itoa(end))
+}
+
+// pageEnd returns the end of the page starting at start, or total without a page size
+func pageEnd(start, size, total int) int {
+	if size <= 0 || start+size > total {
+		return total
+	}
+	return start + size
+}
+
+func (c *Client) ListSecrets(ctx context.Context, params *secretsmanager.ListSecretsInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.ListSecretsOutput, error) {
+	if err := c.intercept("ListSecrets", ""); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls["ListSecrets"]++
+	var prefixes []string
+	for _, filter := range params.Filters {
+		if filter.Key == types.FilterNameStringTypeName {
+			prefixes = append(prefixes, filter.Values...)
+		}
+	}
+	var matching []string
+	for _, name := range c.sortedNames() {
+		if len(prefixes) == 0 || hasAnyPrefix(name, prefixes) {
+			matching = append(matching, name)
+		}
+	}
+	start, err := pageStart(params.NextToken)
+	if err != nil {
+		return nil, err
+	}
+	end := pageEnd(start, c.ListPageSize, len(matching))
+	out := &secretsmanager.ListSecretsOutput{NextToken: nextToken(end, len(matching))}
+	for _, name := range matching[min(start, end):end] {
+		out.SecretList = append(out.SecretList, types.SecretListEntry{Name: aws.String(name)})
+	}
+	return out, nil
+}
+
+func hasAnyPrefix(name string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	if err := c.intercept("GetSecretValue", aws.ToString(params.SecretId)); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name := aws.ToString(params.SecretId)
+	s, err := c.lookup("GetSecretValue", name)
+	if err != nil {
+		return nil, err
+	}
+	id := aws.ToString(params.VersionId)
+	if id == "" {
+		stage := aws.ToString(params.VersionStage)
+		if stage == "" {
+			stage = stageCurrent
+		}
+		id = s.stages[stage]
+	}
+	value, ok := s.versions[id]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{Message: aws.String(fmt.Sprintf("Secrets Manager can't find the specified version of '%s'", name))}
+	}
+	return &secretsmanager.GetSecretValueOutput{Name: aws.String(name), SecretString: aws.String(value), VersionId: aws.String(id)}, nil
+}
+
+func (c *Client) BatchGetSecretValue(ctx context.Context, params *secretsmanager.BatchGetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.BatchGetSecretValueOutput, error) {
+	if err := c.intercept("BatchGetSecretValue", ""); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls["BatchGetSecretValue"]++
+	start, err := pageStart(params.NextToken)
+	if err != nil {
+		return nil, err
+	}
+	ids := params.SecretIdList
+	end := pageEnd(start, c.BatchPageSize, len(ids))
+	out := &secretsmanager.BatchGetSecretValueOutput{NextToken: nextToken(end, len(ids))}
+	for _, name := range ids[min(start, end):end] {
+		s, ok := c.secrets[name]
+		if !ok {
+			out.Errors = append(out.Errors, types.APIErrorType{SecretId: aws.String(name), ErrorCode: aws.String("ResourceNotFoundException"), Message: aws.String("Secrets Manager can't find the specified secret")})
+			continue
+		}
+		id := s.stages[stageCurrent]
+		out.SecretValues = append(out.SecretValues, types.SecretValueEntry{Name: aws.String(name), SecretString: aws.String(s.versions[id]), VersionId: aws.String(id)})
+	}
+	return out, nil
+}
+
+func (c *Client) DescribeSecret(ctx context.Context, params *secretsmanager.DescribeSecretInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.DescribeSecretOutput, error) {
+	if err := c.intercept("DescribeSecret", aws.ToString(params.SecretId)); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name := aws.ToString(params.SecretId)
+	s, err := c.lookup("DescribeSecret", name)
+	if err != nil {
+		return nil, err
+	}
+	out := &secretsmanager.DescribeSecretOutput{Name: aws.String(name), VersionIdsToStages: map[string][]string{}}
+	for stage, id := range s.stages {
+		out.VersionIdsToStages[id] = append(out.VersionIdsToStages[id], stage)
+	}
+	for k, v := range s.tags {
+		out.Tags = append(out.Tags, types.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	for _, region := range s.regions {
+		out.ReplicationStatus = append(out.ReplicationStatus, types.ReplicationStatusType{Region: aws.String(region), Status: types.StatusTypeInSync})
+	}
+	return out, nil
+}
+
+func (c *Client) CreateSecret(ctx context.Context, params *secretsmanager.CreateSecretInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.CreateSecretOutput, error) {
+	if err := c.intercept("CreateSecret", aws.ToString(params.Name)); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls["CreateSecret"]++
+	name := aws.ToString(params.Name)
+	if _, exists := c.secrets[name]; exists {
+		return nil, &types.ResourceExistsException{Message: aws.String(fmt.Sprintf("the secret '%s' already exists", name))}
+	}
+	s := &secret{versions: map[string]string{}, stages: map[string]string{}, tags: map[string]string{}}
+	for _, tag := range params.Tags {
+		s.tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	for _, region := range params.AddReplicaRegions {
+		s.regions = append(s.regions, aws.ToString(region.Region))
+	}
+	c.secrets[name] = s
+	id := s.write(aws.ToString(params.SecretString), aws.ToString(params.ClientRequestToken))
+	return &secretsmanager.CreateSecretOutput{Name: aws.String(name), VersionId: aws.String(id)}, nil
+}
+
+func (c *Client) UpdateSecret(ctx context.Context, params *secretsmanager.UpdateSecretInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.UpdateSecretOutput, error) {
+	if err := c.intercept("UpdateSecret", aws.ToString(params.SecretId)); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name := aws.ToString(params.SecretId)
+	s, err := c.lookup("UpdateSecret", name)
+	if err != nil {
+		return nil, err
+	}
+	id := s.write(aws.ToString(params.SecretString), aws.ToString(params.ClientRequestToken))
+	return &secretsmanager.UpdateSecretOutput{Name: aws.String(name), VersionId: aws.String(id)}, nil
+}
+
+func (c *Client) DeleteSecret(ctx context.Context, params *secretsmanager.DeleteSecretInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.DeleteSecretOutput, error) {
+	if err := c.intercept("DeleteSecret", aws.ToString(params.SecretId)); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name := aws.ToString(params.SecretId)
+	if _, err := c.lookup("DeleteSecret", name); err != nil {
+		return nil, err
+	}
+	delete(c.secrets, name)
+	return &secretsmanager.DeleteSecretOutput{Name: aws.String(name)}, nil
+}
+
+func (c *Client) TagResource(ctx context.Context, params *secretsmanager.TagResourceInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.TagResourceOutput, error) {
+	if err := c.intercept("TagResource", aws.ToString(params.SecretId)); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, err := c.lookup("TagResource", aws.ToString(params.SecretId))
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range params.Tags {
+		s.tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return &secretsmanager.TagResourceOutput{}, nil
+}
+
+func (c *Client) UntagResource(ctx context.Context, params *secretsmanager.UntagResourceInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.UntagResourceOutput, error) {
+	if err := c.intercept("UntagResource", aws.ToString(params.SecretId)); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, err := c.lookup("UntagResource", aws.ToString(params.SecretId))
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range params.TagKeys {
+		delete(s.tags, k)
+	}
+	return &secretsmanager.UntagResourceOutput{}, nil
+}
+
+// UpdateSecretVersionStage moves a label like AWS does: RemoveFromVersionId must be the version
+// holding the label, so a move based on a stale read fails with an InvalidParameterException
+func (c *Client) UpdateSecretVersionStage(ctx context.Context, params *secretsmanager.UpdateSecretVersionStageInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.UpdateSecretVersionStageOutput, error) {
+	if err := c.intercept("UpdateSecretVersionStage", aws.ToString(params.SecretId)); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name := aws.ToString(params.SecretId)
+	s, err := c.lookup("UpdateSecretVersionStage", name)
+	if err != nil {
+		return nil, err
+	}
+	stage := aws.ToString(params.VersionStage)
+	held, attached := s.stages[stage]
+	if from := aws.ToString(params.RemoveFromVersionId); from != "" && (!attached || held != from) {
+		return nil, &types.InvalidParameterException{Message: aws.String(fmt.Sprintf("staging label %s is not attached to version %s of '%s'", stage, from, name))}
+	}
+	if attached && params.RemoveFromVersionId == nil && params.MoveToVersionId != nil {
+		return nil, &types.InvalidParameterException{Message: aws.String(fmt.Sprintf("staging label %s is attached to version %s of '%s'; give RemoveFromVersionId to move it", stage, held, name))}
+	}
+	to := aws.ToString(params.MoveToVersionId)
+	if to == "" {
+		delete(s.stages, stage)
+		return &secretsmanager.UpdateSecretVersionStageOutput{Name: aws.String(name)}, nil
+	}
+	if _, ok := s.versions[to]; !ok {
+		return nil, &types.ResourceNotFoundException{Message: aws.String(fmt.Sprintf("version %s of '%s' does not exist", to, name))}
+	}
+	s.stages[stage] = to
+	return &secretsmanager.UpdateSecretVersionStageOutput{Name: aws.String(name)}, nil
+}
+
+func (c *Client) ReplicateSecretToRegions(ctx context.Context, params *secretsmanager.ReplicateSecretToRegionsInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.ReplicateSecretToRegionsOutput, error) {
+	if err := c.intercept("ReplicateSecretToRegions", aws.ToString(params.SecretId)); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, err := c.lookup("ReplicateSecretToRegions", aws.ToString(params.SecretId))
+	if err != nil {
+		return nil, err
+	}
+	for _, region := range params.AddReplicaRegions {
+		s.regions = append(s.regions, aws.ToString(region.Region))
+	}
+	return &secretsmanager.ReplicateSecretToRegionsOutput{}, nil
+}
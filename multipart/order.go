@@ -0,0 +1,177 @@
+package multipart
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// InsertionOrder records the order in which object keys first appeared in JSON documents,
+// per dot-notation object path ("" for the top level, array elements by their index)
+// It lets parts be chunked and written in the order of a source file instead of alphabetically
+type InsertionOrder struct {
+	keys map[string][]string
+	seen map[string]map[string]bool
+}
+
+// NewInsertionOrder returns an empty InsertionOrder
+func NewInsertionOrder() *InsertionOrder {
+	return &InsertionOrder{keys: map[string][]string{}, seen: map[string]map[string]bool{}}
+}
+
+// Record appends the keys of every object in the JSON document raw that are not recorded yet
+// The document is recorded as the value at path, so input merged under --json_path keeps its order
+func (o *InsertionOrder) Record(raw string, path string) error {
+	dec := json.NewDecoder(strings.NewReader(raw))
+	if err := o.recordValue(dec, path); err != nil {
+		return fmt.Errorf("failed to record key order: %w", err)
+	}
+	return nil
+}
+
+func (o *InsertionOrder) recordValue(dec *json.Decoder, path string) error {
+	join := func(segment string) string {
+		if path == "" {
+			return segment
+		}
+		return path + "." + segment
+	}
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch tok {
+	case json.Delim('{'):
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key := keyTok.(string)
+			o.add(path, key)
+			if err := o.recordValue(dec, join(EscapePathSegment(key))); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+		return err
+	case json.Delim('['):
+		for i := 0; dec.More(); i++ {
+			if err := o.recordValue(dec, join(strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+		return err
+	}
+	return nil
+}
+
+func (o *InsertionOrder) add(path, key string) {
+	if o.seen[path] == nil {
+		o.seen[path] = map[string]bool{}
+	}
+	if !o.seen[path][key] {
+		o.seen[path][key] = true
+		o.keys[path] = append(o.keys[path], key)
+	}
+}
+
+// Keys returns the keys of obj, the object at path: recorded keys first in their recorded order,
+// then any others sorted with KeyLess in keyOrder
+func (o *InsertionOrder) Keys(obj map[string]interface{}, path string, keyOrder string) []string {
+	keys := make([]string, 0, len(obj))
+	for _, k := range o.keys[path] {
+		if _, ok := obj[k]; ok {
+			keys = append(keys, k)
+		}
+	}
+	rest := make([]string, 0, len(obj)-len(keys))
+	for k := range obj {
+		if !o.seen[path][k] {
+			rest = append(rest, k)
+		}
+	}
+	sort.Slice(rest, func(i, j int) bool { return KeyLess(rest[i], rest[j], keyOrder) })
+	return append(keys, rest...)
+}
+
+// Marshal serializes v like MarshalSecret but writes object keys in the recorded order, and keys
+// that were not recorded after them sorted in keyOrder (SortCaseSensitive or SortCaseInsensitive)
+// The output has the same size as MarshalSecret's, so chunk boundaries are unaffected by the order
+func (o *InsertionOrder) Marshal(v interface{}, compact bool, keyOrder string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := o.writeValue(&buf, v, "", compact, keyOrder, ""); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (o *InsertionOrder) writeValue(buf *bytes.Buffer, v interface{}, path string, compact bool, keyOrder string, indent string) error {
+	join := func(segment string) string {
+		if path == "" {
+			return segment
+		}
+		return path + "." + segment
+	}
+	newline := func(level string) {
+		if !compact {
+			buf.WriteString("\n" + level)
+		}
+	}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if len(t) == 0 {
+			buf.WriteString("{}")
+			return nil
+		}
+		buf.WriteString("{")
+		for i, k := range o.Keys(t, path, keyOrder) {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			newline(indent + "  ")
+			kjs, err := json.Marshal(k)
+			if err != nil {
+				return err
+			}
+			buf.Write(kjs)
+			buf.WriteString(":")
+			if !compact {
+				buf.WriteString(" ")
+			}
+			if err := o.writeValue(buf, t[k], join(EscapePathSegment(k)), compact, keyOrder, indent+"  "); err != nil {
+				return err
+			}
+		}
+		newline(indent)
+		buf.WriteString("}")
+	case []interface{}:
+		if len(t) == 0 {
+			buf.WriteString("[]")
+			return nil
+		}
+		buf.WriteString("[")
+		for i, item := range t {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			newline(indent + "  ")
+			if err := o.writeValue(buf, item, join(strconv.Itoa(i)), compact, keyOrder, indent+"  "); err != nil {
+				return err
+			}
+		}
+		newline(indent)
+		buf.WriteString("]")
+	default:
+		js, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		buf.Write(js)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package multipart
+
+import "testing"
+
+func TestInsertionOrderMarshal(t *testing.T) {
+	data := map[string]interface{}{
+		"z": "1", "a": "2", "b": "3", "C": "4",
+		"n": map[string]interface{}{"a": "5", "B": "6"},
+	}
+	tests := []struct {
+		keyOrder string
+		want     string
+	}{
+		{keyOrder: SortCaseSensitive, want: `{"z":"1","a":"2","C":"4","b":"3","n":{"B":"6","a":"5"}}`},
+		{keyOrder: SortCaseInsensitive, want: `{"z":"1","a":"2","b":"3","C":"4","n":{"a":"5","B":"6"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.keyOrder, func(t *testing.T) {
+			order := NewInsertionOrder()
+			if err := order.Record(`{"z":"0","a":"0"}`, ""); err != nil {
+				t.Fatal(err)
+			}
+			js, err := order.Marshal(data, true, tt.keyOrder)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(js) != tt.want {
+				t.Errorf("Marshal = %s, want %s", js, tt.want)
+			}
+			opts := ChunkOptions{KeyOrder: tt.keyOrder, Compact: true, Order: order}
+			if js, err := opts.Marshal(data); err != nil || string(js) != tt.want {
+				t.Errorf("ChunkOptions.Marshal = %s, %v, want %s", js, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package multipart
+
+import (
+	"reflect"
+	"sort"
+)
+
+// ApplyMergePatch applies the RFC 7386 JSON Merge Patch patch to target in place:
+// a null removes the key, an object is merged recursively (replacing a non-object value)
+// and any other value, arrays included, replaces the stored one
+// It returns the number of values set and the sorted dot-notation paths of the keys removed
+func ApplyMergePatch(target, patch map[string]interface{}) (int, []string) {
+	removed := []string{}
+	set := mergePatchObject(target, patch, "", &removed)
+	sort.Strings(removed)
+	return set, removed
+}
+
+func mergePatchObject(target, patch map[string]interface{}, prefix string, removed *[]string) int {
+	set := 0
+	for k, v := range patch {
+		path := EscapePathSegment(k)
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+		if v == nil {
+			if _, exists := target[k]; exists {
+				delete(target, k)
+				*removed = append(*removed, path)
+			}
+			continue
+		}
+		if nested, ok := v.(map[string]interface{}); ok {
+			current, ok := target[k].(map[string]interface{})
+			if !ok {
+				current = map[string]interface{}{}
+				target[k] = current
+				set++
+			}
+			set += mergePatchObject(current, nested, path, removed)
+			continue
+		}
+		if old, exists := target[k]; !exists || !reflect.DeepEqual(old, v) {
+			target[k] = v
+			set++
+		}
+	}
+	return set
+}
@@ -0,0 +1,104 @@
+package multipart
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"golang.org/x/time/rate"
+)
+
+// RateLimitedClient is a SecretsManagerClient that waits for a token of a shared limiter
+// before every call, so concurrent part reads and writes stay below a calls/sec budget
+// SDK retries of a call happen inside the wrapped client and are not counted separately
+type RateLimitedClient struct {
+	client  SecretsManagerClient
+	limiter *rate.Limiter
+}
+
+// NewRateLimitedClient wraps client so it makes at most perSecond calls per second
+// The burst is a single call, so bursts of writes during a redistribution are spread out evenly
+func NewRateLimitedClient(client SecretsManagerClient, perSecond float64) *RateLimitedClient {
+	return &RateLimitedClient{client: client, limiter: rate.NewLimiter(rate.Limit(perSecond), 1)}
+}
+
+// wait blocks until the next call may be made or ctx is done
+func (c *RateLimitedClient) wait(ctx context.Context) error {
+	return c.limiter.Wait(ctx)
+}
+
+func (c *RateLimitedClient) ListSecrets(ctx context.Context, params *secretsmanager.ListSecretsInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.ListSecretsOutput, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.client.ListSecrets(ctx, params, optFns...)
+}
+
+func (c *RateLimitedClient) GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.client.GetSecretValue(ctx, params, optFns...)
+}
+
+func (c *RateLimitedClient) BatchGetSecretValue(ctx context.Context, params *secretsmanager.BatchGetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.BatchGetSecretValueOutput, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.client.BatchGetSecretValue(ctx, params, optFns...)
+}
+
+func (c *RateLimitedClient) DescribeSecret(ctx context.Context, params *secretsmanager.DescribeSecretInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.DescribeSecretOutput, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.client.DescribeSecret(ctx, params, optFns...)
+}
+
+func (c *RateLimitedClient) CreateSecret(ctx context.Context, params *secretsmanager.CreateSecretInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.CreateSecretOutput, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.client.CreateSecret(ctx, params, optFns...)
+}
+
+func (c *RateLimitedClient) UpdateSecret(ctx context.Context, params *secretsmanager.UpdateSecretInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.UpdateSecretOutput, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.client.UpdateSecret(ctx, params, optFns...)
+}
+
+func (c *RateLimitedClient) DeleteSecret(ctx context.Context, params *secretsmanager.DeleteSecretInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.DeleteSecretOutput, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.client.DeleteSecret(ctx, params, optFns...)
+}
+
+func (c *RateLimitedClient) TagResource(ctx context.Context, params *secretsmanager.TagResourceInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.TagResourceOutput, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.client.TagResource(ctx, params, optFns...)
+}
+
+func (c *RateLimitedClient) UntagResource(ctx context.Context, params *secretsmanager.UntagResourceInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.UntagResourceOutput, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.client.UntagResource(ctx, params, optFns...)
+}
+
+func (c *RateLimitedClient) UpdateSecretVersionStage(ctx context.Context, params *secretsmanager.UpdateSecretVersionStageInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.UpdateSecretVersionStageOutput, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.client.UpdateSecretVersionStage(ctx, params, optFns...)
+}
+
+func (c *RateLimitedClient) ReplicateSecretToRegions(ctx context.Context, params *secretsmanager.ReplicateSecretToRegionsInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.ReplicateSecretToRegionsOutput, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.client.ReplicateSecretToRegions(ctx, params, optFns...)
+}
@@ -0,0 +1,960 @@
+package multipart
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/tidwall/gjson"
+)
+
+// SecretsManagerClient interface for AWS Secrets Manager operations
+// This allows us to mock the client for testing; multiparttest.Client is an in-memory implementation
+type SecretsManagerClient interface {
+	ListSecrets(ctx context.Context, params *secretsmanager.ListSecretsInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.ListSecretsOutput, error)
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+	BatchGetSecretValue(ctx context.Context, params *secretsmanager.BatchGetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.BatchGetSecretValueOutput, error)
+	DescribeSecret(ctx context.Context, params *secretsmanager.DescribeSecretInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.DescribeSecretOutput, error)
+	CreateSecret(ctx context.Context, params *secretsmanager.CreateSecretInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.CreateSecretOutput, error)
+	UpdateSecret(ctx context.Context, params *secretsmanager.UpdateSecretInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.UpdateSecretOutput, error)
+	DeleteSecret(ctx context.Context, params *secretsmanager.DeleteSecretInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.DeleteSecretOutput, error)
+	TagResource(ctx context.Context, params *secretsmanager.TagResourceInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.TagResourceOutput, error)
+	UntagResource(ctx context.Context, params *secretsmanager.UntagResourceInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.UntagResourceOutput, error)
+	UpdateSecretVersionStage(ctx context.Context, params *secretsmanager.UpdateSecretVersionStageInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.UpdateSecretVersionStageOutput, error)
+	ReplicateSecretToRegions(ctx context.Context, params *secretsmanager.ReplicateSecretToRegionsInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.ReplicateSecretToRegionsOutput, error)
+}
+
+// Staging labels managed by Secrets Manager itself
+const (
+	StageCurrent  = "AWSCURRENT"
+	StagePrevious = "AWSPREVIOUS"
+)
+
+// DefaultMaxParts is the default highest multipart suffix number (base-1 .. base-5)
+const DefaultMaxParts = 5
+
+// MaxBatchSecretIDs is the AWS limit on secret IDs per BatchGetSecretValue call.
+// Larger part sets are split into several batches that are fetched concurrently.
+const MaxBatchSecretIDs = 20
+
+// maxConcurrentBatches bounds the number of BatchGetSecretValue calls in flight at once
+const maxConcurrentBatches = 4
+
+// rollbackTimeout bounds the restore of already written parts after a failed redistribution,
+// which runs even when the failure was the overall deadline or an interrupt
+const rollbackTimeout = 30 * time.Second
+
+// ErrConcurrentModification is returned when a part changed between being read and written
+var ErrConcurrentModification = errors.New("secret modified concurrently, retry")
+
+// SecretManager wraps the client and provides business logic methods
+type SecretManager struct {
+	client   SecretsManagerClient
+	maxParts int
+
+	// Naming names the parts of a base; NewSecretManager starts with DefaultNaming
+	Naming Naming
+
+	// SyncTags reconciles the tags of existing secrets on update (by default tags are only set on create)
+	SyncTags bool
+	// KmsKeyID is the KMS key used to encrypt newly created secrets (empty uses the AWS managed key)
+	KmsKeyID string
+	// Description returns the description of the part named name, set when the part is created;
+	// nil or an empty result leaves the description unset
+	Description func(name string) string
+	// SyncDescription also sets the Description of existing parts when they are updated
+	SyncDescription bool
+	// Compact stores parts as JSON without indentation
+	Compact bool
+	// Order, when set, writes object keys in the recorded insertion order instead of alphabetically
+	Order *InsertionOrder
+	// KeyOrder sorts the keys Order did not record, SortCaseSensitive or SortCaseInsensitive ("" is case-sensitive)
+	KeyOrder string
+	// WriteConcurrency bounds how many parts RedistributeSecrets writes in parallel (values below 1 mean 1)
+	WriteConcurrency int
+	// Progress receives a line after each part RedistributeSecrets writes and a warning for each
+	// part skipped because of SkipMissingParts; nil disables it
+	Progress io.Writer
+	// VersionStage is the staging label read by GetSecretsData ("" means AWSCURRENT)
+	VersionStage string
+	// MoveStage is a staging label moved to the new version of every part written ("" leaves labels alone)
+	MoveStage string
+	// ForceDelete removes pruned parts immediately instead of scheduling them for deletion
+	ForceDelete bool
+	// ReplicaRegions are the regions every part written is replicated to; created parts get them
+	// with AddReplicaRegions and updated parts are replicated to the ones they are missing
+	ReplicaRegions []string
+	// SkipMissingParts makes reads leave out parts that no longer exist instead of failing,
+	// e.g. when a part was deleted between GetMultipartNumbers and the fetch
+	SkipMissingParts bool
+	// MaxSecretSize is the largest SecretString a write may send (0 means AWSMaxSecretSizeBytes)
+	MaxSecretSize int
+	// Audit is called after every successful create or update of a part; nil disables it
+	// An error it returns fails the write, so no change goes unrecorded
+	Audit func(WriteRecord) error
+}
+
+// WriteRecord describes a part that was just created or updated
+type WriteRecord struct {
+	Name string
+	// Operation is "create" or "update"
+	Operation string
+	// Keys is the number of top-level keys stored in the part
+	Keys  int
+	Bytes int
+}
+
+// NewSecretManager creates a new SecretManager instance
+// maxParts is the highest multipart suffix number that is read or created
+func NewSecretManager(client SecretsManagerClient, maxParts int) *SecretManager {
+	return &SecretManager{client: client, maxParts: maxParts, Naming: DefaultNaming}
+}
+
+// GetMultipartNumbers retrieves all part numbers for a base secret name
+// Uses AWS Secrets Manager prefix filtering to reduce the result set
+func (sm *SecretManager) GetMultipartNumbers(ctx context.Context, base string) ([]int, error) {
+	var numbers []int
+	input := &secretsmanager.ListSecretsInput{
+		Filters: []types.Filter{
+			{
+				Key:    types.FilterNameStringTypeName,
+				Values: []string{base},
+			},
+		},
+	}
+	var nextToken *string
+
+	for {
+		input.NextToken = nextToken
+		resp, err := sm.client.ListSecrets(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, secret := range resp.SecretList {
+			if num, ok := sm.Naming.ParsePartNumber(base, aws.ToString(secret.Name)); ok && num <= sm.maxParts {
+				numbers = append(numbers, num)
+			}
+		}
+
+		if resp.NextToken == nil {
+			break
+		}
+		nextToken = resp.NextToken
+	}
+	return numbers, nil
+}
+
+// GetSecretsData fetches multiple secrets using BatchGetSecretValue
+// Up to MaxBatchSecretIDs names are fetched in a single call; larger sets are split into batches
+// that are fetched concurrently with a bounded worker pool and the errors of all batches are aggregated
+// Returns the SecretString and the VersionId of each secret, keyed by secret name
+// BatchGetSecretValue always returns AWSCURRENT; any other VersionStage is read with GetSecretValue
+func (sm *SecretManager) GetSecretsData(ctx context.Context, secretNames []string) (map[string]string, map[string]string, error) {
+	if len(secretNames) == 0 {
+		return nil, nil, fmt.Errorf("no secret names provided to fetch")
+	}
+	if sm.VersionStage != "" && sm.VersionStage != StageCurrent {
+		return sm.getSecretsDataAtStage(ctx, secretNames, sm.VersionStage)
+	}
+	if len(secretNames) <= MaxBatchSecretIDs {
+		return sm.batchGetSecretsData(ctx, secretNames)
+	}
+
+	var batches [][]string
+	for start := 0; start < len(secretNames); start += MaxBatchSecretIDs {
+		end := start + MaxBatchSecretIDs
+		if end > len(secretNames) {
+			end = len(secretNames)
+		}
+		batches = append(batches, secretNames[start:end])
+	}
+
+	type batchResult struct {
+		data     map[string]string
+		versions map[string]string
+		err      error
+	}
+	results := make([]batchResult, len(batches))
+	sem := make(chan struct{}, maxConcurrentBatches)
+	var wg sync.WaitGroup
+	for i, batch := range batches {
+		wg.Add(1)
+		go func(i int, batch []string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			data, versions, err := sm.batchGetSecretsData(ctx, batch)
+			results[i] = batchResult{data: data, versions: versions, err: err}
+		}(i, batch)
+	}
+	wg.Wait()
+
+	result := make(map[string]string, len(secretNames))
+	versions := make(map[string]string, len(secretNames))
+	var errs []error
+	for _, r := range results {
+		if r.err != nil {
+			errs = append(errs, r.err)
+			continue
+		}
+		for name, value := range r.data {
+			result[name] = value
+			versions[name] = r.versions[name]
+		}
+	}
+	if len(errs) > 0 {
+		return nil, nil, errors.Join(errs...)
+	}
+	return result, versions, nil
+}
+
+// batchGetSecretsData fetches at most MaxBatchSecretIDs secrets with BatchGetSecretValue
+// following NextToken until all pages of the response have been collected
+func (sm *SecretManager) batchGetSecretsData(ctx context.Context, secretNames []string) (map[string]string, map[string]string, error) {
+	input := &secretsmanager.BatchGetSecretValueInput{
+		SecretIdList: secretNames,
+	}
+	result := make(map[string]string)
+	versions := make(map[string]string)
+	var errs []error
+	var nextToken *string
+
+	for {
+		input.NextToken = nextToken
+		resp, err := sm.client.BatchGetSecretValue(ctx, input)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to batch get secret values: %w", err)
+		}
+
+		// Individual secrets that could not be retrieved are reported in Errors rather than failing the call
+		for _, apiErr := range resp.Errors {
+			if sm.SkipMissingParts && aws.ToString(apiErr.ErrorCode) == "ResourceNotFoundException" {
+				continue
+			}
+			errs = append(errs, &BatchSecretError{
+				SecretID: aws.ToString(apiErr.SecretId),
+				Code:     aws.ToString(apiErr.ErrorCode),
+				Message:  aws.ToString(apiErr.Message),
+			})
+		}
+		for _, secret := range resp.SecretValues {
+			result[aws.ToString(secret.Name)] = aws.ToString(secret.SecretString)
+			versions[aws.ToString(secret.Name)] = aws.ToString(secret.VersionId)
+		}
+
+		if resp.NextToken == nil {
+			break
+		}
+		nextToken = resp.NextToken
+	}
+
+	if len(errs) > 0 {
+		return nil, nil, errors.Join(errs...)
+	}
+	return result, versions, nil
+}
+
+// getSecretsDataAtStage fetches the given staging label of each secret with GetSecretValue,
+// bounded by maxConcurrentBatches, and aggregates the errors of all secrets
+func (sm *SecretManager) getSecretsDataAtStage(ctx context.Context, secretNames []string, stage string) (map[string]string, map[string]string, error) {
+	result := make(map[string]string, len(secretNames))
+	versions := make(map[string]string, len(secretNames))
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs []error
+	)
+	sem := make(chan struct{}, maxConcurrentBatches)
+	for _, name := range secretNames {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			resp, err := sm.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+				SecretId:     aws.String(name),
+				VersionStage: aws.String(stage),
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			var notFound *types.ResourceNotFoundException
+			if err != nil && sm.SkipMissingParts && errors.As(err, &notFound) {
+				return
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to fetch stage %s of secret '%s': %w", stage, name, err))
+				return
+			}
+			result[name] = aws.ToString(resp.SecretString)
+			versions[name] = aws.ToString(resp.VersionId)
+		}(name)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return nil, nil, errors.Join(errs...)
+	}
+	return result, versions, nil
+}
+
+// BatchSecretError describes a single secret that BatchGetSecretValue failed to retrieve
+type BatchSecretError struct {
+	SecretID string
+	Code     string
+	Message  string
+}
+
+func (e *BatchSecretError) Error() string {
+	return fmt.Sprintf("failed to fetch secret '%s': %s: %s", e.SecretID, e.Code, e.Message)
+}
+
+// SecretPart holds the parsed data of a single multipart secret
+type SecretPart struct {
+	Name      string
+	Raw       string
+	VersionID string
+	Data      map[string]interface{}
+}
+
+// FetchPartValues fetches the SecretString and VersionId of every part using batch API
+// The names of the parts returned are in the order of numbers (0 = base secret, 1 = base-1, etc.)
+// A part missing from the response is an error unless sm.SkipMissingParts is set, in which case it is left out
+func (sm *SecretManager) FetchPartValues(ctx context.Context, base string, numbers []int) ([]string, map[string]string, map[string]string, error) {
+	if len(numbers) == 0 {
+		return nil, nil, nil, nil
+	}
+
+	// Build list of secret names to fetch
+	secretNames := make([]string, 0, len(numbers))
+	for _, n := range numbers {
+		secretNames = append(secretNames, sm.Naming.PartName(base, n))
+	}
+
+	// Fetch all secrets in a single batch call
+	secretsData, versions, err := sm.GetSecretsData(ctx, secretNames)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	found := make([]string, 0, len(secretNames))
+	for _, secretName := range secretNames {
+		if _, exists := secretsData[secretName]; exists {
+			found = append(found, secretName)
+			continue
+		}
+		if !sm.SkipMissingParts {
+			return nil, nil, nil, WithCode(CodeSecretNotFound, "", fmt.Errorf("secret '%s' not found in batch response", secretName))
+		}
+		if sm.Progress != nil {
+			fmt.Fprintf(sm.Progress, "WARNING: part '%s' no longer exists, skipping it\n", secretName)
+		}
+	}
+	return found, secretsData, versions, nil
+}
+
+// FetchSecretParts fetches and parses every multipart secret using batch API
+// Parts are returned in the order of numbers (0 = base secret, 1 = base-1, etc.)
+func (sm *SecretManager) FetchSecretParts(ctx context.Context, base string, numbers []int) ([]SecretPart, error) {
+	secretNames, secretsData, versions, err := sm.FetchPartValues(ctx, base, numbers)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := make([]SecretPart, 0, len(secretNames))
+	for _, secretName := range secretNames {
+		secretValue := secretsData[secretName]
+
+		var data map[string]interface{}
+		if err := json.Unmarshal([]byte(secretValue), &data); err != nil {
+			return nil, WithCode(CodeInvalidJSON, "", fmt.Errorf("failed to unmarshal secret '%s': %w", secretName, err))
+		}
+		if data == nil {
+			return nil, WithCode(CodeInvalidJSON, "", fmt.Errorf("secret '%s' contains empty/null JSON data", secretName))
+		}
+		parts = append(parts, SecretPart{Name: secretName, Raw: secretValue, VersionID: versions[secretName], Data: data})
+	}
+	return parts, nil
+}
+
+// DescribeParts calls DescribeSecret for every part, in the order of numbers
+func (sm *SecretManager) DescribeParts(ctx context.Context, base string, numbers []int) ([]*secretsmanager.DescribeSecretOutput, error) {
+	descs := make([]*secretsmanager.DescribeSecretOutput, 0, len(numbers))
+	for _, n := range numbers {
+		name := sm.Naming.PartName(base, n)
+		desc, err := sm.client.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{SecretId: aws.String(name)})
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe secret '%s': %w", name, err)
+		}
+		descs = append(descs, desc)
+	}
+	return descs, nil
+}
+
+// FetchAllSecretData fetches all secret data across multipart secrets using batch API
+// numbers: pre-fetched list of multipart numbers (0 = base secret, 1 = base-1, etc.)
+func (sm *SecretManager) FetchAllSecretData(ctx context.Context, base string, numbers []int) (map[string]interface{}, error) {
+	parts, err := sm.FetchSecretParts(ctx, base, numbers)
+	if err != nil {
+		return nil, err
+	}
+	return MergeSecretParts(parts)
+}
+
+// MergeSecretParts merges the data of all parts into a single map
+// A key present in more than one part is an error
+func MergeSecretParts(parts []SecretPart) (map[string]interface{}, error) {
+	all := make(map[string]interface{})
+	for _, part := range parts {
+		for k, v := range part.Data {
+			if _, exists := all[k]; exists {
+				return nil, WithCode(CodeDuplicateKey, k, fmt.Errorf("duplicate key '%s' found in secret part '%s'", k, part.Name))
+			}
+			all[k] = v
+		}
+	}
+	return all, nil
+}
+
+// Policies for resolving duplicate keys in MergeSecretPartsLenient
+const (
+	DuplicateFirstWins = "first"
+	DuplicateLastWins  = "last"
+)
+
+// MergeSecretPartsLenient merges the data of all parts without failing on duplicate keys
+// policy decides whether the first or the last part (in part order) wins for a duplicated key
+// Returns the merged data and, for every duplicated key, the names of all parts containing it
+func MergeSecretPartsLenient(parts []SecretPart, policy string) (map[string]interface{}, map[string][]string) {
+	all := make(map[string]interface{})
+	owners := make(map[string][]string)
+	for _, part := range parts {
+		for k, v := range part.Data {
+			if _, exists := all[k]; !exists || policy == DuplicateLastWins {
+				all[k] = v
+			}
+			owners[k] = append(owners[k], part.Name)
+		}
+	}
+	duplicates := make(map[string][]string)
+	for k, names := range owners {
+		if len(names) > 1 {
+			duplicates[k] = names
+		}
+	}
+	return all, duplicates
+}
+
+// MarshalSecret is the single serializer for stored parts; the chunker measures with it too,
+// so a part's measured size always equals the size of the SecretString that is written
+func MarshalSecret(data interface{}, compact bool) ([]byte, error) {
+	if compact {
+		return json.Marshal(data)
+	}
+	return json.MarshalIndent(data, "", "  ")
+}
+
+// CreateOrModifySecret creates or updates a secret
+// expectedVersion is the VersionId read before modification; when set, the update fails with
+// ErrConcurrentModification if the current version differs
+func (sm *SecretManager) CreateOrModifySecret(ctx context.Context, name string, data map[string]interface{}, tags map[string]string, expectedVersion string) error {
+	var js []byte
+	var err error
+	if sm.Order != nil {
+		js, err = sm.Order.Marshal(data, sm.Compact, sm.KeyOrder)
+	} else {
+		js, err = MarshalSecret(data, sm.Compact)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to marshal secret data: %w", err)
+	}
+	return sm.CreateOrModifySecretString(ctx, name, string(js), tags, expectedVersion)
+}
+
+// partDescription returns the description for the part named name, "" when none is configured
+func (sm *SecretManager) partDescription(name string) string {
+	if sm.Description == nil {
+		return ""
+	}
+	return sm.Description(name)
+}
+
+// CreateOrModifySecretString creates or updates a secret with an already serialized SecretString
+// Tags are only applied when the secret is created
+// A SecretString larger than sm.MaxSecretSize is rejected before any call is made
+func (sm *SecretManager) CreateOrModifySecretString(ctx context.Context, name string, secretString string, tags map[string]string, expectedVersion string) error {
+	limit := sm.MaxSecretSize
+	if limit <= 0 {
+		limit = AWSMaxSecretSizeBytes
+	}
+	if size := SecretSize(secretString); size > limit {
+		return WithCode(CodeSizeExceeded, "", fmt.Errorf("part '%s' is %d bytes, which exceeds the limit of %d bytes; refusing to send it to AWS", name, size, limit))
+	}
+	input := &secretsmanager.DescribeSecretInput{SecretId: aws.String(name)}
+	desc, err := sm.client.DescribeSecret(ctx, input)
+	if err == nil {
+		if expectedVersion != "" {
+			if current := currentVersionID(desc); current != expectedVersion {
+				return fmt.Errorf("%w: '%s' changed since it was read (read version %s, current version %s)", ErrConcurrentModification, name, expectedVersion, current)
+			}
+		}
+		updateInput := &secretsmanager.UpdateSecretInput{
+			SecretId:           aws.String(name),
+			SecretString:       aws.String(secretString),
+			ClientRequestToken: aws.String(clientRequestToken(name, currentVersionID(desc), secretString)),
+		}
+		if sm.SyncDescription {
+			if d := sm.partDescription(name); d != "" && d != aws.ToString(desc.Description) {
+				updateInput.Description = aws.String(d)
+			}
+		}
+		resp, err := sm.client.UpdateSecret(ctx, updateInput)
+		if err != nil {
+			return err
+		}
+		if err := sm.moveStage(ctx, name, aws.ToString(resp.VersionId), stageVersionID(desc, sm.MoveStage)); err != nil {
+			return err
+		}
+		if err := sm.replicateMissing(ctx, name, desc.ReplicationStatus); err != nil {
+			return err
+		}
+		if err := sm.audit(name, "update", secretString); err != nil {
+			return err
+		}
+		if !sm.SyncTags {
+			return nil
+		}
+		return sm.syncSecretTags(ctx, name, desc.Tags, tags)
+	}
+	tagsList := make([]types.Tag, 0, len(tags))
+	for k, v := range tags {
+		tagsList = append(tagsList, types.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	createInput := &secretsmanager.CreateSecretInput{
+		Name:               aws.String(name),
+		SecretString:       aws.String(secretString),
+		Tags:               tagsList,
+		ClientRequestToken: aws.String(clientRequestToken(name, "", secretString)),
+	}
+	if sm.KmsKeyID != "" {
+		createInput.KmsKeyId = aws.String(sm.KmsKeyID)
+	}
+	if d := sm.partDescription(name); d != "" {
+		createInput.Description = aws.String(d)
+	}
+	for _, region := range sm.ReplicaRegions {
+		createInput.AddReplicaRegions = append(createInput.AddReplicaRegions, types.ReplicaRegionType{Region: aws.String(region)})
+	}
+	resp, err := sm.client.CreateSecret(ctx, createInput)
+	if err != nil {
+		return err
+	}
+	if err := sm.audit(name, "create", secretString); err != nil {
+		return err
+	}
+	return sm.moveStage(ctx, name, aws.ToString(resp.VersionId), "")
+}
+
+// audit hands the write of secretString to name to sm.Audit
+func (sm *SecretManager) audit(name, operation, secretString string) error {
+	if sm.Audit == nil {
+		return nil
+	}
+	keys := 0
+	gjson.Parse(secretString).ForEach(func(_, _ gjson.Result) bool {
+		keys++
+		return true
+	})
+	if err := sm.Audit(WriteRecord{Name: name, Operation: operation, Keys: keys, Bytes: SecretSize(secretString)}); err != nil {
+		return fmt.Errorf("'%s' was written but its audit entry could not be recorded: %w", name, err)
+	}
+	return nil
+}
+
+// clientRequestToken derives the idempotency token of a write from the secret name, the VersionId
+// it replaces ("" on create) and the new content. A retried identical write reuses the token and
+// is ignored by AWS, while writing content seen before on top of another version (e.g. a rollback)
+// still gets a new token and therefore a new version
+func clientRequestToken(name, replacing, secretString string) string {
+	sum := sha256.Sum256([]byte(name + "\x00" + replacing + "\x00" + secretString))
+	return hex.EncodeToString(sum[:])
+}
+
+// replicateMissing replicates an existing secret to the regions of sm.ReplicaRegions it is not yet
+// replicated to. Replicas in other regions are left alone
+func (sm *SecretManager) replicateMissing(ctx context.Context, name string, current []types.ReplicationStatusType) error {
+	have := make(map[string]bool, len(current))
+	for _, status := range current {
+		have[aws.ToString(status.Region)] = true
+	}
+	var missing []types.ReplicaRegionType
+	for _, region := range sm.ReplicaRegions {
+		if !have[region] {
+			missing = append(missing, types.ReplicaRegionType{Region: aws.String(region)})
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if _, err := sm.client.ReplicateSecretToRegions(ctx, &secretsmanager.ReplicateSecretToRegionsInput{
+		SecretId:          aws.String(name),
+		AddReplicaRegions: missing,
+	}); err != nil {
+		return fmt.Errorf("failed to replicate '%s' to %d region(s): %w", name, len(missing), err)
+	}
+	return nil
+}
+
+// moveStage attaches MoveStage to the version just written, detaching it from fromVersion if set
+func (sm *SecretManager) moveStage(ctx context.Context, name, toVersion, fromVersion string) error {
+	if sm.MoveStage == "" || toVersion == fromVersion {
+		return nil
+	}
+	input := &secretsmanager.UpdateSecretVersionStageInput{
+		SecretId:        aws.String(name),
+		VersionStage:    aws.String(sm.MoveStage),
+		MoveToVersionId: aws.String(toVersion),
+	}
+	if fromVersion != "" {
+		input.RemoveFromVersionId = aws.String(fromVersion)
+	}
+	if _, err := sm.client.UpdateSecretVersionStage(ctx, input); err != nil {
+		return fmt.Errorf("failed to move staging label %s of '%s': %w", sm.MoveStage, name, err)
+	}
+	return nil
+}
+
+// syncSecretTags reconciles the tags of an existing secret with the desired tags
+// Only the tags that differ are added or removed; reserved "aws:" tags are left untouched
+func (sm *SecretManager) syncSecretTags(ctx context.Context, name string, current []types.Tag, desired map[string]string) error {
+	existing := make(map[string]string, len(current))
+	for _, tag := range current {
+		existing[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+
+	var toTag []types.Tag
+	for k, v := range desired {
+		if ev, ok := existing[k]; !ok || ev != v {
+			toTag = append(toTag, types.Tag{Key: aws.String(k), Value: aws.String(v)})
+		}
+	}
+	var toUntag []string
+	for k := range existing {
+		if _, ok := desired[k]; !ok && !strings.HasPrefix(k, "aws:") {
+			toUntag = append(toUntag, k)
+		}
+	}
+	sort.Strings(toUntag)
+
+	if len(toTag) > 0 {
+		if _, err := sm.client.TagResource(ctx, &secretsmanager.TagResourceInput{
+			SecretId: aws.String(name),
+			Tags:     toTag,
+		}); err != nil {
+			return fmt.Errorf("failed to tag secret '%s': %w", name, err)
+		}
+	}
+	if len(toUntag) > 0 {
+		if _, err := sm.client.UntagResource(ctx, &secretsmanager.UntagResourceInput{
+			SecretId: aws.String(name),
+			TagKeys:  toUntag,
+		}); err != nil {
+			return fmt.Errorf("failed to untag secret '%s': %w", name, err)
+		}
+	}
+	return nil
+}
+
+// CheckVersions fails with ErrConcurrentModification when a secret of versions (name to the VersionId
+// read) no longer has that version as AWSCURRENT. It guards the parts a write relies on but does not rewrite
+func (sm *SecretManager) CheckVersions(ctx context.Context, versions map[string]string) error {
+	names := make([]string, 0, len(versions))
+	for name := range versions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		desc, err := sm.client.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{SecretId: aws.String(name)})
+		if err != nil {
+			return fmt.Errorf("failed to describe secret '%s': %w", name, err)
+		}
+		if current := currentVersionID(desc); current != versions[name] {
+			return fmt.Errorf("%w: '%s' changed since it was read (read version %s, current version %s)", ErrConcurrentModification, name, versions[name], current)
+		}
+	}
+	return nil
+}
+
+// currentVersionID returns the VersionId carrying the AWSCURRENT staging label
+func currentVersionID(desc *secretsmanager.DescribeSecretOutput) string {
+	return stageVersionID(desc, StageCurrent)
+}
+
+// stageVersionID returns the VersionId carrying the given staging label, or "" if none does
+func stageVersionID(desc *secretsmanager.DescribeSecretOutput, label string) string {
+	if label == "" {
+		return ""
+	}
+	for id, stages := range desc.VersionIdsToStages {
+		for _, stage := range stages {
+			if stage == label {
+				return id
+			}
+		}
+	}
+	return ""
+}
+
+// Naming is a multipart naming scheme: part N of base is named base + Separator + N,
+// with N zero-padded to Padding digits (0 disables padding)
+type Naming struct {
+	Separator string
+	Padding   int
+}
+
+// DefaultNaming names the parts base-1, base-2, ...
+var DefaultNaming = Naming{Separator: "-"}
+
+// PartName returns the secret name for a multipart number (0 = base secret)
+func (nm Naming) PartName(base string, n int) string {
+	if n == 0 {
+		return base
+	}
+	return fmt.Sprintf("%s%s%0*d", base, nm.Separator, nm.Padding, n)
+}
+
+// ParsePartNumber returns the multipart number of name for base (0 for the base itself)
+// Only names spelled exactly as PartName would build them match, so with padding 2
+// "base-01" is part 1 while "base-1" is not a part at all
+func (nm Naming) ParsePartNumber(base, name string) (int, bool) {
+	if name == base {
+		return 0, true
+	}
+	suffix, ok := strings.CutPrefix(name, base+nm.Separator)
+	if !ok {
+		return 0, false
+	}
+	num, err := strconv.Atoi(suffix)
+	if err != nil || num < 1 || nm.PartName(base, num) != name {
+		return 0, false
+	}
+	return num, true
+}
+
+// PlanPartNames returns the secret names that count chunks will be written to, in ascending part number
+// Every existing part is reused. Additional parts first fill gaps in the numbering (base, base-1, base-3
+// gets base-2 next) and are only numbered above the highest existing part once the set is contiguous,
+// so a gap left behind by a manual deletion heals on the next write. No part may exceed maxParts
+func (nm Naming) PlanPartNames(base string, numbers []int, count int, maxParts int) ([]string, error) {
+	if count < len(numbers) {
+		return nil, WithCode(CodeEmptyParts, "", fmt.Errorf("number of new chunks (%d) is less than existing multipart secrets (%d). This would leave duplicated keys in extra secrets. Please manually delete extra secrets or check your input", count, len(numbers)))
+	}
+	used := make(map[int]bool, count)
+	for _, n := range numbers {
+		used[n] = true
+	}
+	planned := append([]int(nil), numbers...)
+	for next := 0; len(planned) < count; next++ {
+		if used[next] {
+			continue
+		}
+		if next > maxParts {
+			return nil, WithCode(CodeTooManyParts, "", fmt.Errorf("data requires part '%s' which exceeds the maximum of %d parts. Increase --max-parts or reduce the data", nm.PartName(base, next), maxParts))
+		}
+		planned = append(planned, next)
+	}
+	sort.Ints(planned)
+
+	names := make([]string, 0, count)
+	for _, n := range planned {
+		names = append(names, nm.PartName(base, n))
+	}
+	return names, nil
+}
+
+// RedistributeSecrets redistributes chunks across multipart secrets
+// numbers: pre-fetched list of multipart numbers (0 = base secret, 1 = base-1, etc.)
+// versions: VersionId of each existing part as read before modification, nil disables the concurrency check
+// previous: SecretString of each existing part as read before modification, nil disables rollback
+// When a write fails, the parts already written are restored from previous and parts created by
+// this call are deleted, so the set is left as it was read
+func (sm *SecretManager) RedistributeSecrets(ctx context.Context, base string, chunks []map[string]interface{}, tags map[string]string, numbers []int, versions map[string]string, previous map[string]string) error {
+	names, err := sm.Naming.PlanPartNames(base, numbers, len(chunks), sm.maxParts)
+	if err != nil {
+		return err
+	}
+	// A part that has started writing is allowed to finish even if ctx is cancelled,
+	// so an interrupt never leaves a single secret half-updated; the deadline still applies
+	writeCtx := context.WithoutCancel(ctx)
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		writeCtx, cancel = context.WithDeadline(writeCtx, deadline)
+		defer cancel()
+	}
+
+	// Writes start in part order; after the first failure or cancellation no new write is started
+	limit := sm.WriteConcurrency
+	if limit < 1 {
+		limit = 1
+	}
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		errs    []error
+		done    = make([]bool, len(chunks))
+		written int
+	)
+	sem := make(chan struct{}, limit)
+	for i, chunk := range chunks {
+		sem <- struct{}{}
+		mu.Lock()
+		stop := len(errs) > 0
+		if err := ctx.Err(); err != nil && !stop {
+			errs = append(errs, err)
+			stop = true
+		}
+		mu.Unlock()
+		if stop {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func(i int, chunk map[string]interface{}) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			name := names[i]
+			err := sm.CreateOrModifySecret(writeCtx, name, chunk, tags, versions[name])
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to create/modify secret '%s': %w", name, err))
+				return
+			}
+			done[i] = true
+			written++
+			if sm.Progress != nil {
+				fmt.Fprintf(sm.Progress, "wrote %d/%d parts\n", written, len(chunks))
+			}
+		}(i, chunk)
+	}
+	wg.Wait()
+
+	if len(errs) == 0 {
+		return nil
+	}
+	var writtenNames []string
+	for i, ok := range done {
+		if ok {
+			writtenNames = append(writtenNames, names[i])
+		}
+	}
+	partialErr := &PartialWriteError{Written: writtenNames, Err: errors.Join(errs...)}
+	if previous != nil {
+		sm.rollback(ctx, partialErr, tags, previous)
+	}
+	return partialErr
+}
+
+// rollback restores the parts in e.Written to their previous values, deleting the ones that did not
+// exist before, and records the outcome in e
+func (sm *SecretManager) rollback(ctx context.Context, e *PartialWriteError, tags map[string]string, previous map[string]string) {
+	rollbackCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+	defer cancel()
+	var errs []error
+	var remaining []string
+	for _, name := range e.Written {
+		var err error
+		if value, existed := previous[name]; existed {
+			err = sm.CreateOrModifySecretString(rollbackCtx, name, value, tags, "")
+		} else {
+			// The part was created by this run; deleting it without recovery frees the name for the next run
+			_, err = sm.client.DeleteSecret(rollbackCtx, &secretsmanager.DeleteSecretInput{
+				SecretId:                   aws.String(name),
+				ForceDeleteWithoutRecovery: aws.Bool(true),
+			})
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("'%s': %w", name, err))
+			remaining = append(remaining, name)
+			continue
+		}
+		e.RolledBack = append(e.RolledBack, name)
+	}
+	e.Written = remaining
+	e.RollbackErr = errors.Join(errs...)
+}
+
+// PartialWriteError reports the parts RedistributeSecrets had already written when it stopped
+// Written holds the parts still carrying new data, RolledBack the ones restored after the failure
+type PartialWriteError struct {
+	Written     []string
+	RolledBack  []string
+	RollbackErr error
+	Err         error
+}
+
+func (e *PartialWriteError) Error() string {
+	msg := e.Err.Error()
+	if len(e.RolledBack) > 0 {
+		msg += fmt.Sprintf(" (rolled back: %s)", strings.Join(e.RolledBack, ", "))
+	}
+	if e.RollbackErr != nil {
+		msg += fmt.Sprintf(" (rollback failed: %v)", e.RollbackErr)
+	}
+	switch {
+	case len(e.Written) > 0:
+		msg += fmt.Sprintf(" (parts already written: %s)", strings.Join(e.Written, ", "))
+	case len(e.RolledBack) == 0:
+		msg += " (no parts were written)"
+	}
+	return msg
+}
+
+func (e *PartialWriteError) Unwrap() error {
+	return e.Err
+}
+
+// SplitPrunableParts splits existing part numbers into the ones reused for count chunks
+// and the higher-numbered leftovers that are no longer needed. The base secret is always kept
+func SplitPrunableParts(numbers []int, count int) ([]int, []int) {
+	sorted := append([]int(nil), numbers...)
+	sort.Ints(sorted)
+	if count < 1 {
+		count = 1
+	}
+	if count >= len(sorted) {
+		return sorted, nil
+	}
+	return sorted[:count], sorted[count:]
+}
+
+// DeleteParts schedules deletion of the given multipart secrets using the default recovery window,
+// or deletes them immediately when ForceDelete is set
+// The base secret (number 0) is never deleted
+func (sm *SecretManager) DeleteParts(ctx context.Context, base string, numbers []int) error {
+	for _, n := range numbers {
+		if n == 0 {
+			return fmt.Errorf("refusing to delete base secret '%s'", base)
+		}
+		name := sm.Naming.PartName(base, n)
+		_, err := sm.client.DeleteSecret(ctx, &secretsmanager.DeleteSecretInput{
+			SecretId:                   aws.String(name),
+			ForceDeleteWithoutRecovery: aws.Bool(sm.ForceDelete),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete secret '%s': %w", name, err)
+		}
+	}
+	return nil
+}
\ No newline at end of file
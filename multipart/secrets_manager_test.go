@@ -0,0 +1,446 @@
+package multipart
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"secret-manager/multipart/multiparttest"
+)
+
+var _ SecretsManagerClient = (*multiparttest.Client)(nil)
+
+func TestSyncTags(t *testing.T) {
+	tests := []struct {
+		name       string
+		existing   map[string]string
+		desired    map[string]string
+		want       map[string]string
+		tagCalls   int
+		untagCalls int
+	}{
+		{
+			name:     "unchanged tags make no calls",
+			existing: map[string]string{"env": "dev"},
+			desired:  map[string]string{"env": "dev"},
+			want:     map[string]string{"env": "dev"},
+		},
+		{
+			name:       "changed, added and removed tags",
+			existing:   map[string]string{"env": "dev", "team": "a", "old": "x"},
+			desired:    map[string]string{"env": "prod", "team": "a", "new": "y"},
+			want:       map[string]string{"env": "prod", "team": "a", "new": "y"},
+			tagCalls:   1,
+			untagCalls: 1,
+		},
+		{
+			name:     "reserved aws: tags are kept",
+			existing: map[string]string{"aws:cloudformation:stack-name": "s"},
+			desired:  map[string]string{"env": "dev"},
+			want:     map[string]string{"aws:cloudformation:stack-name": "s", "env": "dev"},
+			tagCalls: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			client := multiparttest.NewClient()
+			sm := NewSecretManager(client, DefaultMaxParts)
+			if err := sm.CreateOrModifySecretString(ctx, "app", `{"a":"1"}`, tt.existing, ""); err != nil {
+				t.Fatal(err)
+			}
+			sm.SyncTags = true
+			if err := sm.CreateOrModifySecretString(ctx, "app", `{"a":"2"}`, tt.desired, ""); err != nil {
+				t.Fatal(err)
+			}
+			if got := client.Tags("app"); !maps.Equal(got, tt.want) {
+				t.Errorf("tags = %v, want %v", got, tt.want)
+			}
+			if got := client.Calls("TagResource"); got != tt.tagCalls {
+				t.Errorf("TagResource calls = %d, want %d", got, tt.tagCalls)
+			}
+			if got := client.Calls("UntagResource"); got != tt.untagCalls {
+				t.Errorf("UntagResource calls = %d, want %d", got, tt.untagCalls)
+			}
+		})
+	}
+}
+
+func TestReplicaRegions(t *testing.T) {
+	tests := []struct {
+		name      string
+		existing  []string
+		regions   []string
+		want      []string
+		replicate int
+	}{
+		{name: "created parts get every region", regions: []string{"us-west-2", "eu-west-1"}, want: []string{"us-west-2", "eu-west-1"}},
+		{name: "updated parts get the missing regions", existing: []string{"us-west-2"}, regions: []string{"us-west-2", "eu-west-1"}, want: []string{"us-west-2", "eu-west-1"}, replicate: 1},
+		{name: "fully replicated parts make no call", existing: []string{"us-west-2"}, regions: []string{"us-west-2"}, want: []string{"us-west-2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			client := multiparttest.NewClient()
+			sm := NewSecretManager(client, DefaultMaxParts)
+			if tt.existing != nil {
+				sm.ReplicaRegions = tt.existing
+				if err := sm.CreateOrModifySecretString(ctx, "app", `{"a":"1"}`, nil, ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+			sm.ReplicaRegions = tt.regions
+			if err := sm.CreateOrModifySecretString(ctx, "app", `{"a":"2"}`, nil, ""); err != nil {
+				t.Fatal(err)
+			}
+			if got := client.Regions("app"); !slices.Equal(got, tt.want) {
+				t.Errorf("regions = %v, want %v", got, tt.want)
+			}
+			if got := client.Calls("ReplicateSecretToRegions"); got != tt.replicate {
+				t.Errorf("ReplicateSecretToRegions calls = %d, want %d", got, tt.replicate)
+			}
+		})
+	}
+}
+
+func TestFetchPaginated(t *testing.T) {
+	tests := []struct {
+		name       string
+		parts      int
+		pageSize   int
+		batchCalls int
+	}{
+		{name: "single page", parts: 5, batchCalls: 1},
+		{name: "pages of two", parts: 5, pageSize: 2, batchCalls: 3},
+		{name: "pages within batches", parts: MaxBatchSecretIDs + 5, pageSize: 7, batchCalls: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			client := multiparttest.NewClient()
+			client.ListPageSize, client.BatchPageSize = tt.pageSize, tt.pageSize
+			want := map[string]interface{}{}
+			for n := 0; n < tt.parts; n++ {
+				key := fmt.Sprintf("k%d", n)
+				client.Put(DefaultNaming.PartName("app", n), fmt.Sprintf(`{"%s":"v"}`, key))
+				want[key] = "v"
+			}
+			sm := NewSecretManager(client, tt.parts)
+			numbers, err := sm.GetMultipartNumbers(ctx, "app")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(numbers) != tt.parts {
+				t.Fatalf("listed %d parts, want %d", len(numbers), tt.parts)
+			}
+			got, err := sm.FetchAllSecretData(ctx, "app", numbers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(got, want) {
+				t.Errorf("fetched %v, want %v", got, want)
+			}
+			if calls := client.Calls("BatchGetSecretValue"); calls != tt.batchCalls {
+				t.Errorf("BatchGetSecretValue calls = %d, want %d", calls, tt.batchCalls)
+			}
+		})
+	}
+}
+
+func TestWriteJustUnderLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		compact bool
+		// extra is added next to the key that fills the part to one byte under the limit
+		extra map[string]interface{}
+		parts int
+	}{
+		{name: "indented part just under the limit", parts: 1},
+		{name: "compact part just under the limit", compact: true, parts: 1},
+		{name: "one more key needs a second part", extra: map[string]interface{}{"z": "v"}, parts: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			opts := ChunkOptions{MaxSize: MaxSecretSizeBytes, Strategy: PackStrategyAlpha, Compact: tt.compact}
+			empty, err := opts.Marshal(map[string]interface{}{"a": ""})
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := map[string]interface{}{"a": strings.Repeat("x", MaxSecretSizeBytes-1-len(empty))}
+			for k, v := range tt.extra {
+				data[k] = v
+			}
+			chunks, err := ChunkDataIntoSecrets(data, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(chunks) != tt.parts {
+				t.Fatalf("%d parts, want %d", len(chunks), tt.parts)
+			}
+			client := multiparttest.NewClient()
+			sm := NewSecretManager(client, DefaultMaxParts)
+			sm.Compact = tt.compact
+			if err := sm.RedistributeSecrets(ctx, "app", chunks, nil, nil, nil, nil); err != nil {
+				t.Fatal(err)
+			}
+			for i, chunk := range chunks {
+				measured, err := opts.Marshal(chunk)
+				if err != nil {
+					t.Fatal(err)
+				}
+				stored, ok := client.Value(DefaultNaming.PartName("app", i))
+				if !ok {
+					t.Fatalf("part %d was not written", i)
+				}
+				if stored != string(measured) {
+					t.Errorf("part %d: stored payload differs from the measured one (%d vs %d bytes)", i, len(stored), len(measured))
+				}
+				if size := SecretSize(stored); size > MaxSecretSizeBytes {
+					t.Errorf("part %d is %d bytes, over %d", i, size, MaxSecretSizeBytes)
+				}
+				if size := SecretSize(stored); i == 0 && size != MaxSecretSizeBytes-1 {
+					t.Errorf("part 0 is %d bytes, want %d", size, MaxSecretSizeBytes-1)
+				}
+			}
+		})
+	}
+}
+
+func TestPlanPartNamesWithGaps(t *testing.T) {
+	tests := []struct {
+		name    string
+		numbers []int
+		count   int
+		want    []string
+		// errText must appear in the error when set
+		errText string
+	}{
+		{name: "existing parts are kept", numbers: []int{0, 1, 3}, count: 3, want: []string{"app", "app-1", "app-3"}},
+		{name: "the gap is filled first", numbers: []int{0, 1, 3}, count: 4, want: []string{"app", "app-1", "app-2", "app-3"}},
+		{name: "then higher numbers follow", numbers: []int{0, 1, 3}, count: 5, want: []string{"app", "app-1", "app-2", "app-3", "app-4"}},
+		{name: "a missing base is filled", numbers: []int{1, 2}, count: 3, want: []string{"app", "app-1", "app-2"}},
+		{name: "fewer chunks than parts", numbers: []int{0, 1, 3}, count: 2, errText: "less than existing multipart secrets"},
+		{name: "beyond max parts", numbers: []int{0, 1, 3}, count: 7, errText: "exceeds the maximum of 5 parts"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DefaultNaming.PlanPartNames("app", tt.numbers, tt.count, DefaultMaxParts)
+			if tt.errText != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errText) {
+					t.Fatalf("error = %v, want %q", err, tt.errText)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("names = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNamingParsePartNumber(t *testing.T) {
+	padded := Naming{Separator: "_", Padding: 2}
+	tests := []struct {
+		naming Naming
+		name   string
+		number int
+		ok     bool
+	}{
+		{naming: DefaultNaming, name: "app", number: 0, ok: true},
+		{naming: DefaultNaming, name: "app-3", number: 3, ok: true},
+		{naming: DefaultNaming, name: "app_3"},
+		{naming: padded, name: "app_03", number: 3, ok: true},
+		{naming: padded, name: "app_3"},
+		{naming: padded, name: "app-03"},
+		{naming: padded, name: padded.LockName("app")},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s%d/%s", tt.naming.Separator, tt.naming.Padding, tt.name), func(t *testing.T) {
+			number, ok := tt.naming.ParsePartNumber("app", tt.name)
+			if number != tt.number || ok != tt.ok {
+				t.Errorf("ParsePartNumber = %d, %v, want %d, %v", number, ok, tt.number, tt.ok)
+			}
+			if ok && tt.naming.PartName("app", number) != tt.name {
+				t.Errorf("PartName(%d) = %s, want %s", number, tt.naming.PartName("app", number), tt.name)
+			}
+		})
+	}
+}
+
+func TestRedistributeFillsGap(t *testing.T) {
+	ctx := context.Background()
+	client := multiparttest.NewClient()
+	client.Put("app", `{"a":"1"}`)
+	client.Put("app-1", `{"b":"2"}`)
+	client.Put("app-3", `{"d":"4"}`)
+	sm := NewSecretManager(client, DefaultMaxParts)
+	sm.Compact = true
+	numbers, err := sm.GetMultipartNumbers(ctx, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks := []map[string]interface{}{{"a": "1"}, {"b": "2"}, {"c": "3"}, {"d": "4"}}
+	if err := sm.RedistributeSecrets(ctx, "app", chunks, nil, numbers, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"app": `{"a":"1"}`, "app-1": `{"b":"2"}`, "app-2": `{"c":"3"}`, "app-3": `{"d":"4"}`}
+	if names := client.Names(); !slices.Equal(names, []string{"app", "app-1", "app-2", "app-3"}) {
+		t.Fatalf("secrets = %v", names)
+	}
+	for name, value := range want {
+		if got, _ := client.Value(name); got != value {
+			t.Errorf("%s = %s, want %s", name, got, value)
+		}
+	}
+}
+
+// readVersions returns the content and VersionId of every part of app as RedistributeSecrets expects them
+func readVersions(t *testing.T, sm *SecretManager, numbers []int) (versions, previous map[string]string) {
+	t.Helper()
+	parts, err := sm.FetchSecretParts(context.Background(), "app", numbers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	versions, previous = map[string]string{}, map[string]string{}
+	for _, part := range parts {
+		versions[part.Name], previous[part.Name] = part.VersionID, part.Raw
+	}
+	return versions, previous
+}
+
+func TestRedistributeRollsBack(t *testing.T) {
+	tests := []struct {
+		name string
+		// failOp and failName name the call that fails
+		failOp, failName string
+		rolledBack       []string
+	}{
+		{name: "update of the second part fails", failOp: "UpdateSecret", failName: "app-2", rolledBack: []string{"app", "app-1"}},
+		{name: "first write fails", failOp: "UpdateSecret", failName: "app"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			client := multiparttest.NewClient()
+			before := map[string]string{"app": `{"a":"1"}`, "app-2": `{"c":"3"}`}
+			for name, value := range before {
+				client.Put(name, value)
+			}
+			sm := NewSecretManager(client, DefaultMaxParts)
+			sm.Compact = true
+			numbers := []int{0, 2}
+			versions, previous := readVersions(t, sm, numbers)
+			client.Intercept = func(op, name string) error {
+				if op == tt.failOp && name == tt.failName {
+					return fmt.Errorf("injected failure")
+				}
+				return nil
+			}
+			// app-1 fills the gap, so it is created by this call and must be deleted again
+			chunks := []map[string]interface{}{{"a": "9"}, {"b": "9"}, {"c": "9"}}
+			err := sm.RedistributeSecrets(ctx, "app", chunks, nil, numbers, versions, previous)
+			var partial *PartialWriteError
+			if !errors.As(err, &partial) {
+				t.Fatalf("error = %v, want a *PartialWriteError", err)
+			}
+			if !slices.Equal(partial.RolledBack, tt.rolledBack) || len(partial.Written) > 0 || partial.RollbackErr != nil {
+				t.Errorf("rolled back %v, still written %v (%v), want %v rolled back", partial.RolledBack, partial.Written, partial.RollbackErr, tt.rolledBack)
+			}
+			if !strings.Contains(err.Error(), "injected failure") {
+				t.Errorf("error %q does not name the cause", err)
+			}
+			if names := client.Names(); !slices.Equal(names, []string{"app", "app-2"}) {
+				t.Errorf("secrets = %v, want the created app-1 deleted", names)
+			}
+			for name, value := range before {
+				if got, _ := client.Value(name); got != value {
+					t.Errorf("%s = %s, want it restored to %s", name, got, value)
+				}
+			}
+		})
+	}
+}
+
+func TestRedistributeVersionMismatch(t *testing.T) {
+	ctx := context.Background()
+	client := multiparttest.NewClient()
+	client.Put("app", `{"a":"1"}`)
+	client.Put("app-1", `{"b":"2"}`)
+	sm := NewSecretManager(client, DefaultMaxParts)
+	sm.Compact = true
+	numbers := []int{0, 1}
+	versions, previous := readVersions(t, sm, numbers)
+	// Another run writes app-1 between the read and the write
+	client.Put("app-1", `{"b":"7"}`)
+	chunks := []map[string]interface{}{{"a": "9"}, {"b": "9"}}
+	err := sm.RedistributeSecrets(ctx, "app", chunks, nil, numbers, versions, previous)
+	if !errors.Is(err, ErrConcurrentModification) {
+		t.Fatalf("error = %v, want ErrConcurrentModification", err)
+	}
+	var partial *PartialWriteError
+	if !errors.As(err, &partial) || !slices.Equal(partial.RolledBack, []string{"app"}) || len(partial.Written) > 0 {
+		t.Fatalf("error = %v, want app rolled back and nothing left written", err)
+	}
+	for name, want := range map[string]string{"app": `{"a":"1"}`, "app-1": `{"b":"7"}`} {
+		if got, _ := client.Value(name); got != want {
+			t.Errorf("%s = %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestRedistributeInterrupted(t *testing.T) {
+	tests := []struct {
+		name     string
+		rollback bool
+		// report must appear in the error
+		report string
+		want   map[string]string
+	}{
+		{name: "without rollback", report: "(parts already written: app)", want: map[string]string{"app": `{"a":"9"}`, "app-1": `{"b":"2"}`}},
+		{name: "with rollback", rollback: true, report: "(rolled back: app)", want: map[string]string{"app": `{"a":"1"}`, "app-1": `{"b":"2"}`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := multiparttest.NewClient()
+			client.Put("app", `{"a":"1"}`)
+			client.Put("app-1", `{"b":"2"}`)
+			sm := NewSecretManager(client, DefaultMaxParts)
+			sm.Compact = true
+			numbers := []int{0, 1}
+			versions, previous := readVersions(t, sm, numbers)
+			if !tt.rollback {
+				previous = nil
+			}
+			// The interrupt arrives while app is being written: app finishes, app-1 is never started
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			client.Intercept = func(op, name string) error {
+				if op == "UpdateSecret" && name == "app" {
+					cancel()
+				}
+				return nil
+			}
+			chunks := []map[string]interface{}{{"a": "9"}, {"b": "9"}}
+			err := sm.RedistributeSecrets(ctx, "app", chunks, nil, numbers, versions, previous)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("error = %v, want context.Canceled", err)
+			}
+			if !strings.Contains(err.Error(), tt.report) {
+				t.Errorf("error %q does not contain %q", err, tt.report)
+			}
+			for name, want := range tt.want {
+				if got, _ := client.Value(name); got != want {
+					t.Errorf("%s = %s, want %s", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"secret-manager/multipart"
+)
+
+// infoOut receives informational messages (overwrite notices etc.)
+// It is switched to stderr when --output json reserves stdout for the result object
+var infoOut io.Writer = os.Stdout
+
+// partSummary describes a single multipart secret written (or to be written) by a redistribution
+type partSummary struct {
+	Name   string `json:"name"`
+	Action string `json:"action"`
+	Keys   int    `json:"keys"`
+	Bytes  int    `json:"bytes"`
+	// Limit is the per-part size limit the chunker packed against
+	Limit int `json:"limit"`
+}
+
+// sizeUsage formats the serialized size of a part against its limit, e.g. "48120/51200 bytes (94%)"
+func (p partSummary) sizeUsage() string {
+	percent := 0
+	if p.Limit > 0 {
+		percent = p.Bytes * 100 / p.Limit
+	}
+	return fmt.Sprintf("%d/%d bytes (%d%%)", p.Bytes, p.Limit, percent)
+}
+
+// operationResult is the --output json result of the add/update/delete flow
+type operationResult struct {
+	Operation string        `json:"operation"`
+	DryRun    bool          `json:"dryRun,omitempty"`
+	TotalKeys int           `json:"totalKeys"`
+	Deleted   []string      `json:"deleted,omitempty"`
+	Parts     []partSummary `json:"parts"`
+	Pruned    []string      `json:"pruned,omitempty"`
+	// Plan lists the API calls of a --dry-run --verbose
+	Plan []apiCall `json:"plan,omitempty"`
+	// KeyMoves lists, for a --dry-run, the parts whose set of keys changes
+	KeyMoves []partKeyMoves `json:"keyMoves,omitempty"`
+	// Timings lists the phase durations recorded with --timings
+	Timings []phaseTiming `json:"timings,omitempty"`
+}
+
+// phaseTiming is the duration of one phase recorded with --timings
+type phaseTiming struct {
+	Phase      string  `json:"phase"`
+	DurationMS float64 `json:"durationMs"`
+}
+
+// phaseTimer records how long each phase of a run takes for --timings
+// A disabled timer records nothing, so callers need not check the flag
+type phaseTimer struct {
+	enabled bool
+	start   time.Time
+	phases  []phaseTiming
+	// reported is set once the timings were included in the --output json result
+	reported bool
+}
+
+func newPhaseTimer(enabled bool) *phaseTimer {
+	return &phaseTimer{enabled: enabled, start: time.Now()}
+}
+
+// track records the time elapsed since start as phase
+func (t *phaseTimer) track(phase string, start time.Time) {
+	if t.enabled {
+		t.phases = append(t.phases, phaseTiming{Phase: phase, DurationMS: durationMS(time.Since(start))})
+	}
+}
+
+// report returns the recorded phases followed by the total so far, or nil when disabled
+func (t *phaseTimer) report() []phaseTiming {
+	if !t.enabled {
+		return nil
+	}
+	return append(append([]phaseTiming(nil), t.phases...), phaseTiming{Phase: "total", DurationMS: durationMS(time.Since(t.start))})
+}
+
+// print writes the report to w, one phase per line
+func (t *phaseTimer) print(w io.Writer) {
+	if !t.enabled {
+		return
+	}
+	fmt.Fprintf(w, "Timings:\n")
+	for _, phase := range t.report() {
+		fmt.Fprintf(w, "  %-10s %8.1f ms\n", phase.Phase, phase.DurationMS)
+	}
+}
+
+func durationMS(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// partKeyMoves lists the top-level keys a write moves into and out of one part
+type partKeyMoves struct {
+	Name string    `json:"name"`
+	In   []keyMove `json:"in,omitempty"`
+	Out  []keyMove `json:"out,omitempty"`
+}
+
+// keyMove is one key entering or leaving a part
+// Part is the part it comes from or goes to, "" for a key that is new or removed
+type keyMove struct {
+	Key  string `json:"key"`
+	Part string `json:"part,omitempty"`
+}
+
+// keyMovements compares the key->part mapping of the existing parts with the one after writing
+// chunks to parts; parts that are neither written nor pruned keep their keys
+func keyMovements(naming multipart.Naming, base string, existing []multipart.SecretPart, parts []partSummary, chunks []map[string]interface{}, pruned []string) []partKeyMoves {
+	before := make(map[string]string)
+	for _, part := range existing {
+		for k := range part.Data {
+			if _, ok := before[k]; !ok {
+				before[k] = part.Name
+			}
+		}
+	}
+	rewritten := make(map[string]bool, len(parts)+len(pruned))
+	after := make(map[string]string)
+	for i, part := range parts {
+		rewritten[part.Name] = true
+		for k := range chunks[i] {
+			after[k] = part.Name
+		}
+	}
+	for _, name := range pruned {
+		rewritten[name] = true
+	}
+	for _, part := range existing {
+		if rewritten[part.Name] {
+			continue
+		}
+		for k := range part.Data {
+			if _, ok := after[k]; !ok {
+				after[k] = part.Name
+			}
+		}
+	}
+
+	moves := map[string]*partKeyMoves{}
+	entry := func(name string) *partKeyMoves {
+		if moves[name] == nil {
+			moves[name] = &partKeyMoves{Name: name}
+		}
+		return moves[name]
+	}
+	for k, from := range before {
+		if to := after[k]; to != from {
+			entry(from).Out = append(entry(from).Out, keyMove{Key: k, Part: to})
+		}
+	}
+	for k, to := range after {
+		if from := before[k]; from != to {
+			entry(to).In = append(entry(to).In, keyMove{Key: k, Part: from})
+		}
+	}
+	result := make([]partKeyMoves, 0, len(moves))
+	for _, m := range moves {
+		sort.Slice(m.In, func(i, j int) bool { return m.In[i].Key < m.In[j].Key })
+		sort.Slice(m.Out, func(i, j int) bool { return m.Out[i].Key < m.Out[j].Key })
+		result = append(result, *m)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, _ := naming.ParsePartNumber(base, result[i].Name)
+		b, _ := naming.ParsePartNumber(base, result[j].Name)
+		return a < b
+	})
+	return result
+}
+
+// printKeyMovements prints one line per part whose keys change, e.g. "app-1: +New (new), -Old moved to app-2"
+func printKeyMovements(out io.Writer, moves []partKeyMoves) {
+	if len(moves) == 0 {
+		fmt.Fprintf(out, "Key movement: none, every key stays in its part\n")
+		return
+	}
+	fmt.Fprintf(out, "Key movement:\n")
+	for _, m := range moves {
+		items := make([]string, 0, len(m.In)+len(m.Out))
+		for _, in := range m.In {
+			if in.Part == "" {
+				items = append(items, fmt.Sprintf("+%s (new)", in.Key))
+				continue
+			}
+			items = append(items, fmt.Sprintf("+%s moved from %s", in.Key, in.Part))
+		}
+		for _, o := range m.Out {
+			if o.Part == "" {
+				items = append(items, fmt.Sprintf("-%s (removed)", o.Key))
+				continue
+			}
+			items = append(items, fmt.Sprintf("-%s moved to %s", o.Key, o.Part))
+		}
+		fmt.Fprintf(out, "  %s: %s\n", m.Name, strings.Join(items, ", "))
+	}
+}
+
+// apiCall is a single AWS API call planned by --dry-run --verbose
+type apiCall struct {
+	Operation string `json:"operation"`
+	// SecretID is empty for calls that are not made to Secrets Manager
+	SecretID string `json:"secretId,omitempty"`
+	// Bytes is the size of the SecretString sent, for calls that send one
+	Bytes int    `json:"bytes,omitempty"`
+	Note  string `json:"note,omitempty"`
+}
+
+// findResult is the --output json result of the find-key flow
+type findResult struct {
+	// Operation is omitted for the entries of a findBatchResult
+	Operation string `json:"operation,omitempty"`
+	Path      string `json:"path"`
+	Found     bool   `json:"found"`
+	// Part is null when the key was not found
+	Part *string `json:"part"`
+}
+
+// valueMatch is a key path matched by --find-value or a wildcard find and the part holding it
+type valueMatch struct {
+	Path string `json:"path"`
+	Part string `json:"part"`
+}
+
+// findPatternResult is the --output json result of a find-key with a wildcard --json_path
+type findPatternResult struct {
+	Operation string       `json:"operation,omitempty"`
+	Pattern   string       `json:"pattern"`
+	Found     bool         `json:"found"`
+	Matches   []valueMatch `json:"matches"`
+}
+
+// findBatchResult is the --output json result of a find-key given several paths
+// Results holds a findResult or findPatternResult per path, in the order given
+type findBatchResult struct {
+	Operation string        `json:"operation"`
+	Found     bool          `json:"found"`
+	Results   []interface{} `json:"results"`
+}
+
+// findValueResult is the --output json result of the find-value flow
+type findValueResult struct {
+	Operation string       `json:"operation"`
+	Found     bool         `json:"found"`
+	Matches   []valueMatch `json:"matches"`
+}
+
+// verifyResult is the --output json result of the verify flow
+type verifyResult struct {
+	Operation string   `json:"operation"`
+	Healthy   bool     `json:"healthy"`
+	Parts     int      `json:"parts"`
+	Problems  []string `json:"problems"`
+}
+
+// partMetadata is the AWS-side metadata of a single part reported by --describe
+type partMetadata struct {
+	Name            string            `json:"name"`
+	ARN             string            `json:"arn"`
+	Description     string            `json:"description,omitempty"`
+	LastChangedDate *time.Time        `json:"lastChangedDate,omitempty"`
+	KmsKeyID        string            `json:"kmsKeyId,omitempty"`
+	RotationEnabled bool              `json:"rotationEnabled"`
+	Tags            map[string]string `json:"tags"`
+}
+
+// describeResult is the --output json result of the describe flow
+type describeResult struct {
+	Operation string         `json:"operation"`
+	Parts     []partMetadata `json:"parts"`
+}
+
+// summarizeParts computes the target name, action and serialized size for each chunk
+// Parts already present in numbers are updated, all others are created
+func summarizeParts(naming multipart.Naming, base string, chunks []map[string]interface{}, numbers []int, maxParts int, opts multipart.ChunkOptions) ([]partSummary, error) {
+	names, err := naming.PlanPartNames(base, numbers, len(chunks), maxParts)
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]bool, len(numbers))
+	for _, n := range numbers {
+		existing[naming.PartName(base, n)] = true
+	}
+
+	parts := make([]partSummary, 0, len(chunks))
+	for i, chunk := range chunks {
+		js, err := opts.Marshal(chunk)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal chunk for '%s': %w", names[i], err)
+		}
+		action := "create"
+		if existing[names[i]] {
+			action = "update"
+		}
+		parts = append(parts, partSummary{Name: names[i], Action: action, Keys: len(chunk), Bytes: multipart.SecretSize(string(js)), Limit: opts.MaxSize})
+	}
+	return parts, nil
+}
+
+// writeJSONResult writes v to out as a single JSON object
+func writeJSONResult(out io.Writer, v interface{}) error {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON output: %w", err)
+	}
+	fmt.Fprintln(out, string(js))
+	return nil
+}
+
+// writeJSONResultFile writes v as a single JSON object to the file at path, replacing it
+func writeJSONResultFile(path string, v interface{}) error {
+	var buf bytes.Buffer
+	if err := writeJSONResult(&buf, v); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("failed to write JSON output to '%s': %w", path, err)
+	}
+	return nil
+}
+
+// marshalIndentOrdered is json.MarshalIndent with two-space indentation whose object keys
+// are ordered by KeyLess in the given order instead of plain byte order
+func marshalIndentOrdered(v interface{}, order string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeOrderedJSON(&buf, v, order, ""); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeOrderedJSON(buf *bytes.Buffer, v interface{}, order string, indent string) error {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if len(t) == 0 {
+			buf.WriteString("{}")
+			return nil
+		}
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return multipart.KeyLess(keys[i], keys[j], order) })
+		buf.WriteString("{\n")
+		for i, k := range keys {
+			kjs, err := json.Marshal(k)
+			if err != nil {
+				return err
+			}
+			buf.WriteString(indent + "  ")
+			buf.Write(kjs)
+			buf.WriteString(": ")
+			if err := writeOrderedJSON(buf, t[k], order, indent+"  "); err != nil {
+				return err
+			}
+			if i < len(keys)-1 {
+				buf.WriteString(",")
+			}
+			buf.WriteString("\n")
+		}
+		buf.WriteString(indent + "}")
+	case []interface{}:
+		if len(t) == 0 {
+			buf.WriteString("[]")
+			return nil
+		}
+		buf.WriteString("[\n")
+		for i, item := range t {
+			buf.WriteString(indent + "  ")
+			if err := writeOrderedJSON(buf, item, order, indent+"  "); err != nil {
+				return err
+			}
+			if i < len(t)-1 {
+				buf.WriteString(",")
+			}
+			buf.WriteString("\n")
+		}
+		buf.WriteString(indent + "]")
+	default:
+		js, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		buf.Write(js)
+	}
+	return nil
+}
+
+// planCalls lists the API calls a run would make for parts and pruned, in the order they are started:
+// the STS lookup of --audit-log when identity is set, taking the lock named lockName when it is not
+// empty, sm.CheckVersions of untouched, the calls of sm.RedistributeSecrets and sm.DeleteParts,
+// and releasing the lock again. Calls that depend on what DescribeSecret returns are marked in Note
+func planCalls(sm *multipart.SecretManager, parts []partSummary, pruned []string, untouched map[string]string, lockName string, identity bool) []apiCall {
+	var calls []apiCall
+	if identity {
+		calls = append(calls, apiCall{Operation: "GetCallerIdentity", Note: "STS, for --audit-log"})
+	}
+	if lockName != "" {
+		calls = append(calls,
+			apiCall{Operation: "DescribeSecret", SecretID: lockName},
+			apiCall{Operation: "CreateSecret", SecretID: lockName, Note: "UpdateSecret after GetSecretValue if it exists; retried while held"},
+			apiCall{Operation: "UpdateSecretVersionStage", SecretID: lockName, Note: "takes " + multipart.LockStage})
+	}
+	checked := make([]string, 0, len(untouched))
+	for name := range untouched {
+		checked = append(checked, name)
+	}
+	sort.Strings(checked)
+	for _, name := range checked {
+		calls = append(calls, apiCall{Operation: "DescribeSecret", SecretID: name, Note: "version check only"})
+	}
+	for _, part := range parts {
+		calls = append(calls, apiCall{Operation: "DescribeSecret", SecretID: part.Name})
+		if part.Action == "create" {
+			call := apiCall{Operation: "CreateSecret", SecretID: part.Name, Bytes: part.Bytes}
+			if len(sm.ReplicaRegions) > 0 {
+				call.Note = fmt.Sprintf("replicated to %d region(s)", len(sm.ReplicaRegions))
+			}
+			calls = append(calls, call)
+		} else {
+			calls = append(calls, apiCall{Operation: "UpdateSecret", SecretID: part.Name, Bytes: part.Bytes})
+		}
+		if sm.MoveStage != "" {
+			calls = append(calls, apiCall{Operation: "UpdateSecretVersionStage", SecretID: part.Name, Note: "moves " + sm.MoveStage})
+		}
+		if len(sm.ReplicaRegions) > 0 && part.Action == "update" {
+			calls = append(calls, apiCall{Operation: "ReplicateSecretToRegions", SecretID: part.Name, Note: "only regions it lacks"})
+		}
+		if sm.SyncTags && part.Action == "update" {
+			calls = append(calls,
+				apiCall{Operation: "TagResource", SecretID: part.Name, Note: "only if tags differ"},
+				apiCall{Operation: "UntagResource", SecretID: part.Name, Note: "only if tags differ"})
+		}
+	}
+	for _, name := range pruned {
+		call := apiCall{Operation: "DeleteSecret", SecretID: name, Note: "recovery window"}
+		if sm.ForceDelete {
+			call.Note = "without recovery"
+		}
+		calls = append(calls, call)
+	}
+	if lockName != "" {
+		calls = append(calls, apiCall{Operation: "UpdateSecretVersionStage", SecretID: lockName, Note: "releases " + multipart.LockStage})
+	}
+	return calls
+}
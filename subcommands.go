@@ -0,0 +1,194 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"secret-manager/multipart"
+)
+
+// subcommand is an operation invoked as `<program> [global flags] <name> [flags]`
+// Only the global flags and the subcommand's own flags are accepted, so mode
+// combinations that make no sense together cannot be expressed
+type subcommand struct {
+	name    string
+	summary string
+	// mode is the mode flag the subcommand implies ("" for add)
+	mode  string
+	flags []string
+}
+
+// globalFlags are shared by every subcommand and may also appear before its name
+var globalFlags = []string{"env", "secret_name", "region", "profile", "endpoint-url", "backend", "dir", "timeout", "max-retries", "retry-max-backoff", "rate", "output", "output-file", "errors", "quiet", "timings", "only-if-exists", "max-parts", "no-multipart", "part-separator", "part-padding"}
+
+// writeFlags are shared by the subcommands that redistribute parts
+var writeFlags = []string{"schema", "tag", "sync-tags", "description", "sync-description", "kms-key-id", "replica-region", "max-secret-size", "max-keys", "dominant-key-threshold", "pack-strategy", "pin", "sort", "compact", "report-duplicates", "duplicate-policy", "lock", "lock-ttl", "full-redistribute", "prune-empty-parts", "backup-dir", "audit-log", "write-concurrency", "move-stage", "no-rollback", "no-concurrency-check", "yes", "dry-run", "verbose"}
+
+var subcommands = []subcommand{
+	{
+		name:    "add",
+		summary: "Add or update keys, optionally under the nested object at --json_path",
+		flags:   append([]string{"json_data", "json_file", "dotted-keys", "stringify-values", "validate-nested", "strict-keys", "json_path", "force_update", "merge", "skip-existing", "preserve-types", "binary-key", "encrypt-keys", "encryption-key-file", "init", "preserve-order"}, writeFlags...),
+	},
+	{
+		name:    "find",
+		summary: "Print which part holds each key at --json_path",
+		mode:    "find-key",
+		flags:   []string{"json_path", "paths-file", "jsonpath", "all-occurrences", "prefix", "version-stage", "missing-parts", "parts"},
+	},
+	{
+		name:    "delete",
+		summary: "Remove each key at --json_path and repack the remaining keys",
+		mode:    "delete-key",
+		flags:   append([]string{"json_path", "paths-file", "force-delete", "preserve-order"}, writeFlags...),
+	},
+	{
+		name:    "set",
+		summary: "Replace the value of the existing key at --set-key",
+		flags:   append([]string{"set-key", "value", "value-file", "preserve-types", "encrypt-keys", "encryption-key-file", "preserve-order"}, writeFlags...),
+	},
+	{
+		name:    "append",
+		summary: "Append --value as a string element to the existing array at --append-to",
+		flags:   append([]string{"append-to", "value", "value-file", "encrypt-keys", "encryption-key-file", "preserve-order"}, writeFlags...),
+	},
+	{
+		name:    "patch",
+		summary: "Apply the RFC 7386 JSON Merge Patch in --merge-patch; null removes a key",
+		flags:   append([]string{"merge-patch", "strict-keys", "validate-nested", "encrypt-keys", "encryption-key-file", "preserve-order"}, writeFlags...),
+	},
+	{
+		name:    "copy",
+		summary: "Copy every key to the parts of the base name given with --copy-to",
+		flags:   append([]string{"copy-to", "force_update"}, writeFlags...),
+	},
+	{
+		name:    "normalize",
+		summary: "Repack every key without changing values, e.g. to even out parts after many adds",
+		mode:    "normalize",
+		flags:   writeFlags,
+	},
+	{
+		name:    "list",
+		summary: "Print every key and the part it lives in",
+		mode:    "list-keys",
+		flags:   []string{"recursive", "prefix", "version-stage", "missing-parts", "parts"},
+	},
+	{
+		name:    "describe",
+		summary: "Print the AWS-side metadata (ARN, KMS key, rotation, tags) of every part",
+		mode:    "describe",
+		flags:   []string{"parts"},
+	},
+	{
+		name:    "watch",
+		summary: "Poll the parts every --interval and print a line whenever keys or part sizes change",
+		mode:    "watch",
+		flags:   []string{"interval"},
+	},
+}
+
+// lookupSubcommand returns the subcommand with the given name
+func lookupSubcommand(name string) (subcommand, bool) {
+	for _, cmd := range subcommands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return subcommand{}, false
+}
+
+// subFlagSet builds a FlagSet holding only the named flags of all
+// The flags share their values with all, so parsing it fills the same variables
+func subFlagSet(all *flag.FlagSet, name string, names []string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	for _, n := range names {
+		f := all.Lookup(n)
+		if f == nil {
+			panic(fmt.Sprintf("subcommand flag --%s is not defined", n))
+		}
+		fs.Var(f.Value, f.Name, f.Usage)
+	}
+	return fs
+}
+
+// parseArgs parses args either as `[global flags] <subcommand> [flags]` or, when no
+// subcommand is given, as the original mode-flag invocation against all
+// It returns the names of the flags given explicitly, before or after the subcommand name
+// Errors of the flag package are printed on stderr by the flag sets; the others are returned
+// with CodeUsage for run to report, once --errors is known
+func parseArgs(all *flag.FlagSet, args []string, stderr io.Writer) (map[string]bool, error) {
+	fail := func(format string, a ...interface{}) error {
+		return multipart.WithCode(multipart.CodeUsage, "", fmt.Errorf(format, a...))
+	}
+	if err := all.Parse(args); err != nil {
+		return nil, err
+	}
+	explicit := map[string]bool{}
+	all.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	if all.NArg() == 0 {
+		return explicit, nil
+	}
+	cmd, ok := lookupSubcommand(all.Arg(0))
+	if !ok {
+		return nil, fail("unknown subcommand '%s' (expected one of %s)", all.Arg(0), subcommandNames())
+	}
+	global := make(map[string]bool, len(globalFlags))
+	for _, n := range globalFlags {
+		global[n] = true
+	}
+	var misplaced []string
+	all.Visit(func(f *flag.Flag) {
+		if !global[f.Name] {
+			misplaced = append(misplaced, "--"+f.Name)
+		}
+	})
+	if len(misplaced) > 0 {
+		return nil, fail("%s must come after the '%s' subcommand", strings.Join(misplaced, ", "), cmd.name)
+	}
+
+	fs := subFlagSet(all, all.Name()+" "+cmd.name, append(append([]string(nil), globalFlags...), cmd.flags...), stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: %s [flags]\n%s\n", fs.Name(), cmd.summary)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(all.Args()[1:]); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fail("unexpected argument '%s' after the '%s' subcommand", fs.Arg(0), cmd.name)
+	}
+	// The subcommand's flags share their values with all, but only fs records that they were given
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	if cmd.mode != "" {
+		if err := all.Set(cmd.mode, "true"); err != nil {
+			return nil, err
+		}
+		explicit[cmd.mode] = true
+	}
+	return explicit, nil
+}
+
+// subcommandNames lists the subcommand names for messages
+func subcommandNames() string {
+	names := make([]string, 0, len(subcommands))
+	for _, cmd := range subcommands {
+		names = append(names, cmd.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// printUsage describes both invocation styles followed by every flag of all
+func printUsage(all *flag.FlagSet, stderr io.Writer) {
+	fmt.Fprintf(stderr, "Usage: %s [global flags] <subcommand> [flags]\n", all.Name())
+	fmt.Fprintf(stderr, "   or: %s [flags]\n\nSubcommands:\n", all.Name())
+	for _, cmd := range subcommands {
+		fmt.Fprintf(stderr, "  %-9s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(stderr, "\nGlobal flags: --%s\nRun '%s <subcommand> -h' for the flags of a subcommand.\n\nAll flags:\n", strings.Join(globalFlags, ", --"), all.Name())
+	all.PrintDefaults()
+	fmt.Fprintf(stderr, "\n%s", exitCodes)
+}
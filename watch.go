@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"secret-manager/multipart"
+)
+
+// watchSnapshot is what a --watch poll observed: the hash and size of every part and the
+// part holding every key path. Values never leave the snapshot, so the change lines are safe for logs
+type watchSnapshot struct {
+	parts map[string]watchPart
+	keys  map[string]string
+}
+
+type watchPart struct {
+	hash  [sha256.Size]byte
+	bytes int
+}
+
+// takeWatchSnapshot lists and fetches the parts of base once
+func takeWatchSnapshot(ctx context.Context, sm *multipart.SecretManager, base string, noMultipart bool) (watchSnapshot, error) {
+	numbers := []int{0}
+	if !noMultipart {
+		var err error
+		numbers, err = sm.GetMultipartNumbers(ctx, base)
+		if err != nil {
+			return watchSnapshot{}, fmt.Errorf("failed to get multipart numbers: %w", err)
+		}
+	}
+	parts, err := sm.FetchSecretParts(ctx, base, numbers)
+	if err != nil {
+		return watchSnapshot{}, fmt.Errorf("failed to fetch secrets: %w", err)
+	}
+	snap := watchSnapshot{parts: make(map[string]watchPart, len(parts)), keys: map[string]string{}}
+	for _, part := range parts {
+		snap.parts[part.Name] = watchPart{hash: sha256.Sum256([]byte(part.Raw)), bytes: multipart.SecretSize(part.Raw)}
+		for _, path := range collectKeyPaths(part.Data, "", true, nil) {
+			snap.keys[path] = part.Name
+		}
+	}
+	return snap, nil
+}
+
+// watchChanges describes how next differs from prev, one entry per kind of change; nil when nothing changed
+func watchChanges(prev, next watchSnapshot) []string {
+	var added, removed, moved []string
+	for path, part := range next.keys {
+		old, ok := prev.keys[path]
+		switch {
+		case !ok:
+			added = append(added, path)
+		case old != part:
+			moved = append(moved, fmt.Sprintf("%s (%s -> %s)", path, old, part))
+		}
+	}
+	for path := range prev.keys {
+		if _, ok := next.keys[path]; !ok {
+			removed = append(removed, path)
+		}
+	}
+	var parts []string
+	for name, part := range next.parts {
+		old, ok := prev.parts[name]
+		switch {
+		case !ok:
+			parts = append(parts, fmt.Sprintf("%s created (%d bytes)", name, part.bytes))
+		case old.hash != part.hash:
+			parts = append(parts, fmt.Sprintf("%s changed (%d -> %d bytes)", name, old.bytes, part.bytes))
+		}
+	}
+	for name, part := range prev.parts {
+		if _, ok := next.parts[name]; !ok {
+			parts = append(parts, fmt.Sprintf("%s removed (was %d bytes)", name, part.bytes))
+		}
+	}
+
+	var changes []string
+	for _, c := range []struct {
+		label string
+		items []string
+	}{{"parts", parts}, {"added keys", added}, {"removed keys", removed}, {"moved keys", moved}} {
+		if len(c.items) > 0 {
+			sort.Strings(c.items)
+			changes = append(changes, fmt.Sprintf("%s: %s", c.label, strings.Join(c.items, ", ")))
+		}
+	}
+	return changes
+}
+
+// watchParts polls base every interval until ctx is done and prints a line for every detected change
+// Each poll gets its own timeout. A failed first poll is returned; later failures are reported and the
+// watch continues, so a transient AWS error does not end the monitoring
+func watchParts(ctx context.Context, out, errOut io.Writer, sm *multipart.SecretManager, base string, noMultipart bool, interval, timeout time.Duration) error {
+	poll := func() (watchSnapshot, error) {
+		pollCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return takeWatchSnapshot(pollCtx, sm, base, noMultipart)
+	}
+	prev, err := poll()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Watching '%s' every %s: %d key(s) in %d part(s)\n", base, interval, len(prev.keys), len(prev.parts))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		next, err := poll()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			fmt.Fprintf(errOut, "WARNING: poll failed, retrying in %s: %v\n", interval, err)
+			continue
+		}
+		if changes := watchChanges(prev, next); len(changes) > 0 {
+			fmt.Fprintf(out, "%s change detected in '%s': %s\n", time.Now().UTC().Format(time.RFC3339), base, strings.Join(changes, "; "))
+		}
+		prev = next
+	}
+}